@@ -1,12 +1,26 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
+// Auth modes
+const (
+	AuthModeBot  = "bot"
+	AuthModeUser = "user"
+)
+
+// Access modes
+const (
+	AccessModeOpen     = "open"
+	AccessModeApproval = "approval"
+)
+
 // Config holds all configuration for the application
 type Config struct {
 	// Telegram credentials
@@ -14,6 +28,62 @@ type Config struct {
 	APIHash  string
 	BotToken string
 
+	// Sign in as a user account ("user") instead of a bot ("bot")
+	AuthMode     string
+	PhoneNumber  string
+	AllowedChats []int64 // Chats handled in user mode besides Saved Messages
+
+	// Who may use the bot: everyone not denied ("open") or only those allowed ("approval")
+	AccessMode  string
+	BotAdmins   []int64  // Always allowed; manage access with /allow, /deny and /pending
+	AccessAllow []string // User IDs, chat IDs and @usernames
+	AccessDeny  []string
+
+	// Extra bots downloads are spread over
+	DownloadBotTokens []string
+
+	// Download tuning
+	PoolSize            int
+	PoolMinSize         int
+	PoolAdaptive        bool
+	ChunkSize           int64
+	DownloadConcurrency int
+	VerifyDownloads     bool // Check chunks against Telegram's file hashes
+
+	// Global download scheduling
+	MaxInflightChunks int  // Chunk requests in flight across all downloads (0 disables scheduling)
+	MaxQueuedChunks   int  // Waiting chunk requests before new downloads get 503
+	TrustProxy        bool // Identify clients by X-Forwarded-For
+
+	// Memory budget of the chunk cache in MiB (0 disables it)
+	ChunkCacheMB int64
+
+	// Directory and size cap in MiB of the disk chunk cache (no directory disables it)
+	DiskCacheDir string
+	DiskCacheMB  int64
+
+	// Download rate limits in KiB/s (0 for unlimited)
+	ThrottleGlobalKiB  int64
+	ThrottleIPKiB      int64
+	ThrottleLinkKiB    int64
+	ThrottleTiers      map[string]int64 // Per-owner limit by tier name
+	ThrottleOwnerTiers map[int64]string // Owners' tiers, others are in "default"
+	ThrottleOverQuota  int64            // Per owner once their monthly egress is used up
+
+	// Per-user quotas (0 for unlimited)
+	QuotaMaxLinks      int64
+	QuotaMaxLinkedMB   int64
+	QuotaMonthlyEgress int64 // MiB
+
+	// Bearer token for the /admin endpoints (unset disables them)
+	AdminToken string
+
+	// Days audit log entries are kept, 0 for forever
+	AuditRetentionDays int
+
+	// Bearer token for /metrics (unset leaves it open)
+	MetricsToken string
+
 	// HTTP server
 	HTTPPort int
 	BaseURL  string
@@ -21,6 +91,18 @@ type Config struct {
 	// Storage
 	DBPath      string
 	SessionPath string
+
+	// Telegram channel that files uploaded over HTTP are posted to (0 disables uploads)
+	StorageChannelID int64
+	UploadThreads    int
+
+	// Copy every file sent to the bot into the storage channel and refresh references from there
+	MirrorToChannel bool
+
+	// Channel indexing: auto-link every file posted in these channels
+	IndexChannels          []int64
+	IndexReplyInDiscussion bool
+	IndexEditPosts         bool
 }
 
 // Load reads configuration from environment variables
@@ -38,14 +120,199 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	storageChannelID, err := strconv.ParseInt(getEnv("STORAGE_CHANNEL_ID", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadThreads, err := strconv.Atoi(getEnv("UPLOAD_THREADS", "8"))
+	if err != nil {
+		return nil, err
+	}
+
+	indexChannels, err := getEnvInt64List("INDEX_CHANNELS")
+	if err != nil {
+		return nil, err
+	}
+
+	poolSize, err := strconv.Atoi(getEnv("POOL_SIZE", "8"))
+	if err != nil {
+		return nil, err
+	}
+
+	poolMinSize, err := strconv.Atoi(getEnv("POOL_MIN_SIZE", "2"))
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize, err := strconv.ParseInt(getEnv("CHUNK_SIZE", "1048576"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadConcurrency, err := strconv.Atoi(getEnv("DOWNLOAD_CONCURRENCY", "1"))
+	if err != nil {
+		return nil, err
+	}
+
+	maxInflightChunks, err := strconv.Atoi(getEnv("MAX_INFLIGHT_CHUNKS", "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	maxQueuedChunks, err := strconv.Atoi(getEnv("MAX_QUEUED_CHUNKS", "256"))
+	if err != nil {
+		return nil, err
+	}
+
+	chunkCacheMB, err := strconv.ParseInt(getEnv("CHUNK_CACHE_MB", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	diskCacheMB, err := strconv.ParseInt(getEnv("DISK_CACHE_MB", "10240"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	throttleGlobal, err := strconv.ParseInt(getEnv("THROTTLE_GLOBAL_KIB", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	throttleIP, err := strconv.ParseInt(getEnv("THROTTLE_IP_KIB", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	throttleLink, err := strconv.ParseInt(getEnv("THROTTLE_LINK_KIB", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	throttleOverQuota, err := strconv.ParseInt(getEnv("THROTTLE_OVER_QUOTA_KIB", "256"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	quotaMaxLinks, err := strconv.ParseInt(getEnv("QUOTA_MAX_LINKS", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	quotaMaxLinkedMB, err := strconv.ParseInt(getEnv("QUOTA_MAX_LINKED_MB", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	quotaMonthlyEgress, err := strconv.ParseInt(getEnv("QUOTA_MONTHLY_EGRESS_MB", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	auditRetentionDays, err := strconv.Atoi(getEnv("AUDIT_RETENTION_DAYS", "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	throttleTiers := make(map[string]int64)
+	for name, rate := range getEnvPairs("THROTTLE_TIERS") {
+		if throttleTiers[name], err = strconv.ParseInt(rate, 10, 64); err != nil {
+			return nil, fmt.Errorf("THROTTLE_TIERS: %w", err)
+		}
+	}
+
+	throttleOwnerTiers := make(map[int64]string)
+	for owner, tier := range getEnvPairs("THROTTLE_OWNER_TIERS") {
+		ownerID, err := strconv.ParseInt(owner, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("THROTTLE_OWNER_TIERS: %w", err)
+		}
+		throttleOwnerTiers[ownerID] = tier
+	}
+
+	allowedChats, err := getEnvInt64List("USER_ALLOWED_CHATS")
+	if err != nil {
+		return nil, err
+	}
+
+	authMode := strings.ToLower(getEnv("AUTH_MODE", AuthModeBot))
+	if authMode != AuthModeBot && authMode != AuthModeUser {
+		return nil, fmt.Errorf("AUTH_MODE must be %q or %q", AuthModeBot, AuthModeUser)
+	}
+
+	botAdmins, err := getEnvInt64List("BOT_ADMINS")
+	if err != nil {
+		return nil, err
+	}
+
+	accessMode := strings.ToLower(getEnv("ACCESS_MODE", AccessModeOpen))
+	if accessMode != AccessModeOpen && accessMode != AccessModeApproval {
+		return nil, fmt.Errorf("ACCESS_MODE must be %q or %q", AccessModeOpen, AccessModeApproval)
+	}
+	if accessMode == AccessModeApproval && len(botAdmins) == 0 {
+		return nil, fmt.Errorf("ACCESS_MODE %q needs BOT_ADMINS to approve requests", AccessModeApproval)
+	}
+
 	return &Config{
-		APIID:       apiID,
-		APIHash:     getEnv("API_HASH", ""),
-		BotToken:    getEnv("BOT_TOKEN", ""),
+		APIID:    apiID,
+		APIHash:  getEnv("API_HASH", ""),
+		BotToken: getEnv("BOT_TOKEN", ""),
+
+		AuthMode:     authMode,
+		PhoneNumber:  getEnv("PHONE_NUMBER", ""),
+		AllowedChats: allowedChats,
+
+		AccessMode:  accessMode,
+		BotAdmins:   botAdmins,
+		AccessAllow: getEnvList("ACCESS_ALLOW"),
+		AccessDeny:  getEnvList("ACCESS_DENY"),
+
+		DownloadBotTokens: getEnvList("DOWNLOAD_BOT_TOKENS"),
+
+		PoolSize:            poolSize,
+		PoolMinSize:         poolMinSize,
+		PoolAdaptive:        getEnvBool("POOL_ADAPTIVE"),
+		ChunkSize:           chunkSize,
+		DownloadConcurrency: downloadConcurrency,
+		VerifyDownloads:     getEnvBool("VERIFY_DOWNLOADS"),
+
+		MaxInflightChunks: maxInflightChunks,
+		MaxQueuedChunks:   maxQueuedChunks,
+		TrustProxy:        getEnvBool("TRUST_PROXY"),
+
+		ChunkCacheMB: chunkCacheMB,
+		DiskCacheDir: getEnv("DISK_CACHE_DIR", ""),
+		DiskCacheMB:  diskCacheMB,
+
+		ThrottleGlobalKiB:  throttleGlobal,
+		ThrottleIPKiB:      throttleIP,
+		ThrottleLinkKiB:    throttleLink,
+		ThrottleTiers:      throttleTiers,
+		ThrottleOwnerTiers: throttleOwnerTiers,
+		ThrottleOverQuota:  throttleOverQuota,
+
+		QuotaMaxLinks:      quotaMaxLinks,
+		QuotaMaxLinkedMB:   quotaMaxLinkedMB,
+		QuotaMonthlyEgress: quotaMonthlyEgress,
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
+
+		AuditRetentionDays: auditRetentionDays,
+		MetricsToken:       getEnv("METRICS_TOKEN", ""),
+
 		HTTPPort:    httpPort,
 		BaseURL:     getEnv("BASE_URL", "http://localhost:8080"),
 		DBPath:      getEnv("DB_PATH", "./data/metadata.db"),
 		SessionPath: getEnv("SESSION_PATH", "./data/session"),
+
+		StorageChannelID: storageChannelID,
+		UploadThreads:    uploadThreads,
+		MirrorToChannel:  getEnvBool("MIRROR_TO_CHANNEL"),
+
+		IndexChannels:          indexChannels,
+		IndexReplyInDiscussion: getEnvBool("INDEX_REPLY_IN_DISCUSSION"),
+		IndexEditPosts:         getEnvBool("INDEX_EDIT_POSTS"),
 	}, nil
 }
 
@@ -56,3 +323,51 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvBool reports whether a variable is set to a true value ("1", "true", "yes")
+func getEnvBool(key string) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+// getEnvInt64List parses a comma-separated list of integers
+func getEnvInt64List(key string) ([]int64, error) {
+	var list []int64
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// getEnvPairs parses a comma-separated list of key=value pairs
+func getEnvPairs(key string) map[string]string {
+	pairs := make(map[string]string)
+	for _, field := range getEnvList(key) {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			pairs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return pairs
+}
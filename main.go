@@ -7,6 +7,9 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+
+	"github.com/gotd/td/tg"
 
 	"tele-bot/config"
 	"tele-bot/server"
@@ -20,8 +23,32 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	if cfg.APIID == 0 || cfg.APIHash == "" || cfg.BotToken == "" {
-		log.Fatal("API_ID, API_HASH, and BOT_TOKEN are required. Please check your .env file")
+	if cfg.APIID == 0 || cfg.APIHash == "" {
+		log.Fatal("API_ID and API_HASH are required. Please check your .env file")
+	}
+	userMode := cfg.AuthMode == config.AuthModeUser
+	if userMode && cfg.PhoneNumber == "" {
+		log.Fatal("AUTH_MODE=user requires PHONE_NUMBER to be set")
+	}
+	if !userMode && cfg.BotToken == "" {
+		log.Fatal("BOT_TOKEN is required. Please check your .env file")
+	}
+
+	if cfg.MirrorToChannel && cfg.StorageChannelID == 0 {
+		log.Fatal("MIRROR_TO_CHANNEL requires STORAGE_CHANNEL_ID to be set")
+	}
+
+	if err := telegram.ConfigureDownloads(cfg.ChunkSize, cfg.DownloadConcurrency); err != nil {
+		log.Fatalf("Invalid download settings: %v", err)
+	}
+	if cfg.VerifyDownloads && cfg.ChunkSize < telegram.HashBlockSize {
+		log.Fatalf("VERIFY_DOWNLOADS needs CHUNK_SIZE of at least %d", telegram.HashBlockSize)
+	}
+
+	quotas := storage.Quotas{
+		MaxLinks:       cfg.QuotaMaxLinks,
+		MaxLinkedBytes: cfg.QuotaMaxLinkedMB * 1024 * 1024,
+		MonthlyEgress:  cfg.QuotaMonthlyEgress * 1024 * 1024,
 	}
 
 	log.Println("Starting Telegram Link Generator Service...")
@@ -36,11 +63,25 @@ func main() {
 	log.Println("Database initialized")
 
 	// Create Telegram client with dispatcher
-	client, dispatcher, err := telegram.NewClient(cfg.APIID, cfg.APIHash, cfg.BotToken, cfg.SessionPath)
+	var client *telegram.Client
+	var dispatcher *tg.UpdateDispatcher
+	if userMode {
+		client, dispatcher, err = telegram.NewUserClient(cfg.APIID, cfg.APIHash, cfg.PhoneNumber, cfg.SessionPath)
+	} else {
+		client, dispatcher, err = telegram.NewClient(cfg.APIID, cfg.APIHash, cfg.BotToken, cfg.SessionPath)
+	}
 	if err != nil {
 		log.Fatalf("Failed to create Telegram client: %v", err)
 	}
 	defer client.Close() // Clean up connection pool on exit
+	client.ConfigurePool(telegram.PoolOptions{
+		Size:     cfg.PoolSize,
+		MinSize:  cfg.PoolMinSize,
+		Adaptive: cfg.PoolAdaptive,
+	})
+	if len(cfg.DownloadBotTokens) > 0 {
+		client.AddDownloadBots(cfg.DownloadBotTokens)
+	}
 	log.Println("✅ Client and dispatcher created")
 
 	// Set up context with cancellation
@@ -50,6 +91,14 @@ func main() {
 	// Start the application
 	errChan := make(chan error, 2)
 
+	// The bot and the HTTP API share one set of access rules
+	access := telegram.AccessControl{
+		Mode:   cfg.AccessMode,
+		Admins: cfg.BotAdmins,
+		Allow:  cfg.AccessAllow,
+		Deny:   cfg.AccessDeny,
+	}
+
 	// Run Telegram client
 	go func() {
 		err := client.Run(ctx, cfg.BotToken, func(api *telegram.Client) error {
@@ -58,6 +107,61 @@ func main() {
 			// Create HTTP server with pooled API for parallel downloads
 			httpServer := server.New(store, api.PooledAPI(), cfg.BaseURL)
 			log.Println("📥 Server using connection pool for parallel requests")
+			if len(cfg.DownloadBotTokens) > 0 {
+				httpServer.SetWorkers(api.Workers())
+			}
+			httpServer.SetTrustProxy(cfg.TrustProxy)
+			if cfg.MaxInflightChunks > 0 {
+				httpServer.SetScheduler(telegram.NewScheduler(cfg.MaxInflightChunks, cfg.MaxQueuedChunks))
+				log.Printf("🚦 Scheduling downloads with %d chunk requests in flight", cfg.MaxInflightChunks)
+			}
+			if cfg.ChunkCacheMB > 0 {
+				httpServer.SetCache(telegram.NewChunkCache(cfg.ChunkCacheMB * 1024 * 1024))
+				log.Printf("🗃 Chunk cache enabled with %d MiB", cfg.ChunkCacheMB)
+			}
+			if cfg.VerifyDownloads {
+				httpServer.SetVerify(true)
+				log.Println("🔏 Verifying downloads against Telegram's file hashes")
+			}
+			if cfg.DiskCacheDir != "" && cfg.DiskCacheMB > 0 {
+				disk, err := telegram.NewDiskCache(cfg.DiskCacheDir, cfg.DiskCacheMB*1024*1024)
+				if err != nil {
+					return fmt.Errorf("failed to open disk cache: %w", err)
+				}
+				httpServer.SetDiskCache(disk)
+			}
+			httpServer.SetThrottle(server.NewThrottle(server.ThrottleLimits{
+				Global:     cfg.ThrottleGlobalKiB,
+				PerIP:      cfg.ThrottleIPKiB,
+				PerLink:    cfg.ThrottleLinkKiB,
+				Tiers:      cfg.ThrottleTiers,
+				OwnerTiers: cfg.ThrottleOwnerTiers,
+				OverQuota:  cfg.ThrottleOverQuota,
+			}))
+			httpServer.SetQuotas(quotas)
+			if cfg.AdminToken != "" {
+				httpServer.SetAdmin(cfg.AdminToken, api)
+			}
+			httpServer.SetBotAdmins(cfg.BotAdmins)
+			httpServer.SetAccessPolicy(telegram.NewAccessPolicy(access))
+			httpServer.SetMetricsToken(cfg.MetricsToken)
+			go store.RunRetention(ctx, time.Duration(cfg.AuditRetentionDays)*24*time.Hour)
+			if cfg.AuditRetentionDays > 0 {
+				log.Printf("🧾 Keeping audit log entries for %d day(s)", cfg.AuditRetentionDays)
+			}
+
+			// Enable HTTP uploads when a storage channel is configured
+			var storageChannel *tg.InputPeerChannel
+			if cfg.StorageChannelID != 0 {
+				channel, err := telegram.ResolveChannel(ctx, api.API(), cfg.StorageChannelID)
+				if err != nil {
+					return fmt.Errorf("failed to resolve storage channel: %w", err)
+				}
+				storageChannel = channel
+				httpServer.SetUploader(telegram.NewUploader(api.API(), api.PooledAPI(), storageChannel, cfg.UploadThreads))
+				go store.RunTusExpiry(ctx)
+				log.Printf("📤 HTTP uploads enabled, posting to channel %d", storageChannel.ChannelID)
+			}
 
 			// Start HTTP server in a goroutine
 			go func() {
@@ -71,6 +175,29 @@ func main() {
 
 			// Create message handler with standard API (single connection is fine for messaging)
 			handler := telegram.NewHandler(api.API(), store, cfg.BaseURL)
+			handler.SetQuotas(quotas)
+			handler.SetAccessControl(access)
+			handler.SetServiceStats(func() telegram.ServiceStats {
+				active, served := httpServer.Downloads()
+				return telegram.ServiceStats{ActiveDownloads: active, BytesServed: served}
+			})
+			if storageChannel != nil {
+				handler.SetStorageChannel(storageChannel)
+			}
+			if cfg.MirrorToChannel {
+				handler.SetMirrorChannel(storageChannel)
+				log.Printf("🪞 Mirroring incoming files to channel %d", storageChannel.ChannelID)
+			}
+			if userMode {
+				handler.SetUserMode(api.Self().ID, cfg.AllowedChats)
+			}
+			if len(cfg.IndexChannels) > 0 {
+				handler.SetChannelIndexing(telegram.ChannelIndexing{
+					Channels:          cfg.IndexChannels,
+					ReplyInDiscussion: cfg.IndexReplyInDiscussion,
+					EditPosts:         cfg.IndexEditPosts,
+				})
+			}
 
 			// Register handlers with the dispatcher (the client is already listening!)
 			if err := handler.Register(ctx, dispatcher); err != nil {
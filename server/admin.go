@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"tele-bot/storage"
+	"tele-bot/telegram"
+)
+
+// SetAdmin enables the /admin endpoints, authenticated with a static bearer token
+func (s *Server) SetAdmin(token string, client *telegram.Client) {
+	s.adminToken = token
+	s.client = client
+}
+
+// registerAdmin registers the admin endpoints when an admin token is configured
+func (s *Server) registerAdmin() {
+	if s.adminToken == "" {
+		return
+	}
+	http.HandleFunc("/admin/pool", s.adminAuth(s.handleAdminPool))
+	http.HandleFunc("/admin/cache", s.adminAuth(s.handleAdminCache))
+	http.HandleFunc("/admin/throttle", s.adminAuth(s.handleAdminThrottle))
+}
+
+// adminAuth checks the admin bearer token before calling next
+func (s *Server) adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAdminPool reports the download connection pool, the load of each download bot and the download queue
+func (s *Server) handleAdminPool(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use GET")
+		return
+	}
+
+	var body struct {
+		Pool      *telegram.PoolStats      `json:"pool"` // null when the pool couldn't be created
+		Workers   []telegram.WorkerStats   `json:"workers"`
+		Scheduler *telegram.SchedulerStats `json:"scheduler,omitempty"`
+	}
+	if stats, ok := s.client.PoolStats(); ok {
+		body.Pool = &stats
+	}
+	body.Workers = s.client.Workers().Stats()
+	if s.scheduler != nil {
+		stats := s.scheduler.Stats()
+		body.Scheduler = &stats
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handleAdminCache reports the hit rate and size of the memory and disk chunk caches
+func (s *Server) handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use GET")
+		return
+	}
+	if s.cache == nil && s.disk == nil {
+		writeAPIError(w, http.StatusNotFound, "cache_disabled", "The chunk caches are disabled")
+		return
+	}
+
+	var body struct {
+		*telegram.CacheStats                          // Memory cache, omitted when disabled
+		Disk                 *telegram.DiskCacheStats `json:"disk,omitempty"`
+	}
+	if s.cache != nil {
+		stats := s.cache.Stats()
+		body.CacheStats = &stats
+	}
+	if s.disk != nil {
+		stats := s.disk.Stats()
+		body.Disk = &stats
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handleAdminThrottle reports the download rate limits and how often they held downloads back (GET),
+// or replaces the limits (PUT)
+func (s *Server) handleAdminThrottle(w http.ResponseWriter, r *http.Request) {
+	if s.throttle == nil {
+		writeAPIError(w, http.StatusNotFound, "throttle_disabled", "Download throttling is disabled")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var limits ThrottleLimits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_body", "Body must be a JSON object of limits")
+			return
+		}
+		if limits.Global < 0 || limits.PerIP < 0 || limits.PerLink < 0 || limits.OverQuota < 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_limit", "Limits can't be negative")
+			return
+		}
+		for _, rate := range limits.Tiers {
+			if rate < 0 {
+				writeAPIError(w, http.StatusBadRequest, "invalid_limit", "Limits can't be negative")
+				return
+			}
+		}
+
+		// Limits are audited or not changed at all. The admin token has no user behind it.
+		detail, _ := json.Marshal(limits)
+		entry := &storage.AuditEntry{Action: storage.AuditThrottle, Detail: string(detail), ClientIP: s.clientIP(r)}
+		if err := s.storage.AddAudit(entry); err != nil {
+			log.Printf("Error auditing download limits: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+			return
+		}
+		s.throttle.SetLimits(limits)
+		log.Printf("🚦 Download limits changed: %+v", limits)
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use GET or PUT")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Limits ThrottleLimits `json:"limits"`
+		Stats  ThrottleStats  `json:"stats"`
+	}{s.throttle.Limits(), s.throttle.Stats()})
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gotd/td/tg"
+	"golang.org/x/crypto/bcrypt"
+
+	"tele-bot/storage"
+	"tele-bot/telegram"
+)
+
+const (
+	// defaultPageSize and maxPageSize bound the "limit" query parameter of list endpoints
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// apiHandler is an API endpoint called with the ID of the authenticated user
+type apiHandler func(w http.ResponseWriter, r *http.Request, userID int64)
+
+// apiError is the JSON error body returned by all API endpoints
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// fileList is a page of files
+type fileList struct {
+	Files      []*FileInfo `json:"files"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// filePatch holds the fields PATCH may change; absent fields are left as they are
+type filePatch struct {
+	FileName  *string         `json:"file_name"`
+	ExpiresAt json.RawMessage `json:"expires_at"` // RFC 3339 time, or null to remove the expiry
+	Password  *string         `json:"password"`   // "" removes the password
+}
+
+// registerAPI adds the /api/v1 routes
+func (s *Server) registerAPI() {
+	http.HandleFunc("/api/v1/openapi.json", s.handleOpenAPI)
+	http.HandleFunc("/api/v1/files", s.apiAuth(s.handleAPIFiles))
+	http.HandleFunc("/api/v1/files/", s.apiAuth(s.handleAPIFile))
+	http.HandleFunc("/api/v1/upload", s.apiAuth(s.handleAPIUpload))
+	http.HandleFunc("/api/v1/audit", s.apiAuth(s.handleAPIAudit))
+	http.HandleFunc(tusPath, s.handleTus)
+}
+
+// SetAccessPolicy holds API token owners to the bot's access rules
+func (s *Server) SetAccessPolicy(policy storage.AccessPolicy) {
+	s.access = policy
+}
+
+// apiAuth authenticates requests with a bearer API token minted by the /token bot command,
+// then checks that its owner may still use the bot
+func (s *Server) apiAuth(next apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Missing bearer token")
+			return
+		}
+
+		userID, err := s.storage.GetAPITokenUser(strings.TrimSpace(token))
+		if err != nil {
+			log.Printf("Error checking API token: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+			return
+		}
+		if userID == 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Invalid API token")
+			return
+		}
+		if s.storage.CheckAccess(s.access, userID) != storage.AccessAllowed {
+			writeAPIError(w, http.StatusForbidden, "forbidden", "Access to the bot was denied or hasn't been approved")
+			return
+		}
+
+		next(w, r, userID)
+	}
+}
+
+// handleOpenAPI serves the OpenAPI description of the API
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// handleAPIFiles lists the user's files, newest first
+func (s *Server) handleAPIFiles(w http.ResponseWriter, r *http.Request, userID int64) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use GET to list files")
+		return
+	}
+
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeAPIError(w, http.StatusBadRequest, "invalid_limit", "limit must be between 1 and 200")
+			return
+		}
+		limit = n
+	}
+
+	beforeID, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_cursor", "Malformed cursor")
+		return
+	}
+
+	// Fetch one extra row to learn whether there's a next page
+	files, err := s.storage.ListFilesByOwner(userID, beforeID, limit+1)
+	if err != nil {
+		log.Printf("Error listing files: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+		return
+	}
+
+	list := fileList{Files: []*FileInfo{}}
+	if len(files) > limit {
+		files = files[:limit]
+		list.NextCursor = encodeCursor(files[limit-1].ID)
+	}
+	for _, meta := range files {
+		list.Files = append(list.Files, s.newFileInfo(meta))
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleAPIFile fetches, updates or deletes one of the user's files
+func (s *Server) handleAPIFile(w http.ResponseWriter, r *http.Request, userID int64) {
+	linkID := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/v1/files/"))
+
+	meta, err := s.storage.GetFileByLink(linkID)
+	if err != nil {
+		log.Printf("Error getting file metadata: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+		return
+	}
+	// Other users' files are reported as missing rather than forbidden
+	if meta == nil || meta.OwnerID != userID {
+		writeAPIError(w, http.StatusNotFound, "not_found", "File not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.newFileInfo(meta))
+
+	case http.MethodPatch:
+		if err := applyFilePatch(meta, r); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if err := s.storage.UpdateFile(meta); err != nil {
+			log.Printf("Error updating file: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+			return
+		}
+		log.Printf("✏️ API: user %d updated %s", userID, meta.LinkID)
+		writeJSON(w, http.StatusOK, s.newFileInfo(meta))
+
+	case http.MethodDelete:
+		if err := s.storage.DeleteFile(meta.LinkID, userID, "api"); err != nil {
+			log.Printf("Error deleting file: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+			return
+		}
+		log.Printf("🗑 API: user %d deleted %s", userID, meta.LinkID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use GET, PATCH or DELETE")
+	}
+}
+
+// handleAPIUpload streams the request body to Telegram, posts it to the storage channel and returns the new link
+func (s *Server) handleAPIUpload(w http.ResponseWriter, r *http.Request, userID int64) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use POST to upload")
+		return
+	}
+	if s.uploader == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "uploads_disabled", "Uploads need STORAGE_CHANNEL_ID to be configured")
+		return
+	}
+
+	// Parts are numbered up front, so the size must be known before the first byte is sent
+	if r.ContentLength < 0 {
+		writeAPIError(w, http.StatusLengthRequired, "length_required", "Content-Length is required")
+		return
+	}
+	if r.ContentLength == 0 {
+		writeAPIError(w, http.StatusBadRequest, "empty_body", "Request body is empty")
+		return
+	}
+	if r.ContentLength > telegram.MaxUploadSize {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "too_large", "Files may be at most 2000 MiB")
+		return
+	}
+
+	fileName, ok := uploadFileName(r)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_file_name", "Pass the file name as ?filename= or X-File-Name")
+		return
+	}
+	mimeType := uploadMimeType(r, fileName)
+	if !s.checkLinkQuota(w, userID, r.ContentLength) {
+		return
+	}
+
+	log.Printf("📤 API upload from user %d: %s (%s)", userID, fileName, telegram.FormatFileSize(r.ContentLength))
+
+	ctx := r.Context()
+	input, err := s.uploader.Upload(ctx, r.Body, r.ContentLength, fileName)
+	if err != nil {
+		log.Printf("❌ Upload failed: %v", err)
+		writeAPIError(w, http.StatusBadGateway, "upload_failed", "Failed to upload file to Telegram")
+		return
+	}
+
+	meta, err := s.publishUpload(ctx, userID, input, fileName, mimeType)
+	if err != nil {
+		log.Printf("❌ Publish failed: %v", err)
+		writeAPIError(w, http.StatusBadGateway, "upload_failed", "Failed to post file to the storage channel")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, s.newFileInfo(meta))
+}
+
+// publishUpload posts an uploaded file to the storage channel and records a link for it,
+// exactly as if the user had sent the file to the bot
+func (s *Server) publishUpload(ctx context.Context, userID int64, input tg.InputFileClass, fileName, mimeType string) (*storage.FileMetadata, error) {
+	msg, err := s.uploader.Publish(ctx, input, fileName, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := telegram.ExtractFileMetadata(msg.Media)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("storage channel message %d has no file", msg.ID)
+	}
+
+	meta.LinkID = uuid.New().String()
+	meta.OwnerID = userID
+	meta.SourceChannelID = s.uploader.Channel().ChannelID
+	meta.SourceAccessHash = s.uploader.Channel().AccessHash
+	meta.SourceMessageID = msg.ID
+	if err := s.storage.SaveFile(meta); err != nil {
+		return nil, fmt.Errorf("failed to save file metadata: %w", err)
+	}
+
+	log.Printf("✅ HTTP upload complete: %s -> %s", meta.FileName, s.GenerateDownloadLink(meta.LinkID))
+	return meta, nil
+}
+
+// uploadFileName reads the name of an uploaded file from the query, X-File-Name or Content-Disposition
+func uploadFileName(r *http.Request) (string, bool) {
+	name := r.URL.Query().Get("filename")
+	if name == "" {
+		name = r.Header.Get("X-File-Name")
+	}
+	if name == "" {
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+			name = params["filename"]
+		}
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, "/\\\"\r\n") {
+		return "", false
+	}
+	return name, true
+}
+
+// uploadMimeType returns the request's Content-Type, or guesses one from the file name
+func uploadMimeType(r *http.Request, fileName string) string {
+	if contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && contentType != genericMimeType {
+		return contentType
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(fileName)); byExt != "" {
+		return byExt
+	}
+	return genericMimeType
+}
+
+// applyFilePatch decodes a PATCH body into meta
+func applyFilePatch(meta *storage.FileMetadata, r *http.Request) error {
+	var patch filePatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return errors.New("body must be a JSON object")
+	}
+
+	if patch.FileName != nil {
+		name := strings.TrimSpace(*patch.FileName)
+		if name == "" || strings.ContainsAny(name, "/\\\"\r\n") {
+			return errors.New("file_name must be non-empty and may not contain slashes, quotes or newlines")
+		}
+		meta.FileName = name
+	}
+
+	if len(patch.ExpiresAt) > 0 {
+		if string(patch.ExpiresAt) == "null" {
+			meta.ExpiresAt = nil
+		} else {
+			var expiresAt time.Time
+			if err := json.Unmarshal(patch.ExpiresAt, &expiresAt); err != nil {
+				return errors.New("expires_at must be an RFC 3339 time or null")
+			}
+			expiresAt = expiresAt.UTC()
+			meta.ExpiresAt = &expiresAt
+		}
+	}
+
+	if patch.Password != nil {
+		if *patch.Password == "" {
+			meta.PasswordHash = ""
+		} else {
+			hash, err := bcrypt.GenerateFromPassword([]byte(*patch.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return errors.New("password is too long")
+			}
+			meta.PasswordHash = string(hash)
+		}
+	}
+
+	return nil
+}
+
+// encodeCursor turns the last row ID of a page into an opaque cursor
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+// decodeCursor reverses encodeCursor; an empty cursor is the first page
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// writeAPIError writes a JSON error body
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	var body apiError
+	body.Error.Code = code
+	body.Error.Message = message
+	writeJSON(w, status, body)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tele-bot/storage"
+)
+
+// auditInfo is an audit log entry as returned by the API
+type auditInfo struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ActorID   int64     `json:"actor_id"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	ByteRange string    `json:"byte_range,omitempty"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// auditList is a page of audit log entries
+type auditList struct {
+	Entries    []*auditInfo `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// auditCSVHeader names the columns of a CSV export
+var auditCSVHeader = []string{"id", "created_at", "actor_id", "action", "target", "detail",
+	"client_ip", "user_agent", "byte_range", "bytes"}
+
+// SetBotAdmins lets the API tokens of these users read the audit log
+func (s *Server) SetBotAdmins(userIDs []int64) {
+	s.botAdmins = make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		s.botAdmins[id] = true
+	}
+}
+
+func newAuditInfo(e *storage.AuditEntry) *auditInfo {
+	return &auditInfo{
+		ID:        e.ID,
+		CreatedAt: e.CreatedAt,
+		ActorID:   e.ActorID,
+		Action:    e.Action,
+		Target:    e.Target,
+		Detail:    e.Detail,
+		ClientIP:  e.ClientIP,
+		UserAgent: e.UserAgent,
+		ByteRange: e.ByteRange,
+		Bytes:     e.Bytes,
+	}
+}
+
+// handleAPIAudit lists audit log entries, newest first, as a JSON page or a CSV or JSONL export
+func (s *Server) handleAPIAudit(w http.ResponseWriter, r *http.Request, userID int64) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use GET to read the audit log")
+		return
+	}
+	if !s.botAdmins[userID] {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Only bot admins can read the audit log")
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_filter", err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json":
+	case "csv", "jsonl":
+		s.exportAudit(w, filter, format)
+		return
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_format", "format must be json, csv or jsonl")
+		return
+	}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	// Fetch one extra row to learn whether there's a next page
+	filter.Limit = limit + 1
+	entries, err := s.storage.ListAudit(filter)
+	if err != nil {
+		log.Printf("Error listing audit log: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+		return
+	}
+
+	list := auditList{Entries: []*auditInfo{}}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		list.NextCursor = encodeCursor(entries[limit-1].ID)
+	}
+	for _, e := range entries {
+		list.Entries = append(list.Entries, newAuditInfo(e))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// exportAudit streams every entry matching filter as CSV or JSON lines
+func (s *Server) exportAudit(w http.ResponseWriter, filter storage.AuditFilter, format string) {
+	contentType := "text/csv"
+	if format == "jsonl" {
+		contentType = "application/jsonl"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.%s"`,
+		time.Now().UTC().Format("20060102-150405"), format))
+
+	var write func(*storage.AuditEntry) error
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+		if err := cw.Write(auditCSVHeader); err != nil {
+			return
+		}
+		write = func(e *storage.AuditEntry) error {
+			return cw.Write([]string{
+				strconv.FormatInt(e.ID, 10), e.CreatedAt.UTC().Format(time.RFC3339), strconv.FormatInt(e.ActorID, 10),
+				e.Action, e.Target, e.Detail, e.ClientIP, e.UserAgent, e.ByteRange, strconv.FormatInt(e.Bytes, 10),
+			})
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		write = func(e *storage.AuditEntry) error {
+			return enc.Encode(newAuditInfo(e))
+		}
+	}
+
+	// Headers are already sent, so a failure can only cut the export short
+	if err := s.storage.EachAudit(filter, write); err != nil {
+		log.Printf("Error exporting audit log: %v", err)
+	}
+}
+
+// parseAuditFilter reads the action, actor, target, since, until, limit and cursor query parameters
+func parseAuditFilter(r *http.Request) (storage.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := storage.AuditFilter{
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+	}
+
+	if v := q.Get("actor"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("actor must be a user ID")
+		}
+		filter.ActorID = id
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*t = parsed
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		filter.Limit = n
+	}
+
+	beforeID, err := decodeCursor(q.Get("cursor"))
+	if err != nil {
+		return filter, fmt.Errorf("malformed cursor")
+	}
+	filter.BeforeID = beforeID
+	return filter, nil
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"tele-bot/storage"
+	"tele-bot/telegram"
+)
+
+// FileInfo is the public JSON representation of a stored file
+type FileInfo struct {
+	LinkID      string     `json:"link_id"`
+	FileName    string     `json:"file_name"`
+	FileSize    int64      `json:"file_size"`
+	MimeType    string     `json:"mime_type"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DownloadURL string     `json:"download_url"`
+	StreamURL   string     `json:"stream_url"`
+	ThumbURL    string     `json:"thumb_url,omitempty"`
+	Media       *MediaInfo `json:"media,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Protected   bool       `json:"password_protected"`
+	SHA256      string     `json:"sha256,omitempty"` // Hex digest, once a verified full download computed it
+}
+
+// MediaInfo holds the video/audio attributes of a file
+type MediaInfo struct {
+	Kind              string  `json:"kind"`
+	Width             int     `json:"width,omitempty"`
+	Height            int     `json:"height,omitempty"`
+	Duration          float64 `json:"duration,omitempty"`
+	SupportsStreaming bool    `json:"supports_streaming,omitempty"`
+	Title             string  `json:"title,omitempty"`
+	Performer         string  `json:"performer,omitempty"`
+}
+
+// newFileInfo builds the public representation of a file
+func (s *Server) newFileInfo(meta *storage.FileMetadata) *FileInfo {
+	info := &FileInfo{
+		LinkID:      meta.LinkID,
+		FileName:    meta.FileName,
+		FileSize:    meta.FileSize,
+		MimeType:    meta.MimeType,
+		CreatedAt:   meta.CreatedAt,
+		DownloadURL: s.GenerateDownloadLink(meta.LinkID),
+		StreamURL:   s.baseURL + "/stream/" + meta.LinkID,
+		ExpiresAt:   meta.ExpiresAt,
+		Protected:   meta.PasswordHash != "",
+	}
+	if len(meta.SHA256) > 0 {
+		info.SHA256 = hex.EncodeToString(meta.SHA256)
+	}
+
+	if meta.ThumbType != "" || len(meta.ThumbStripped) > 0 {
+		info.ThumbURL = s.baseURL + "/thumb/" + meta.LinkID
+	}
+
+	if meta.MediaKind != "" {
+		info.Media = &MediaInfo{
+			Kind:              meta.MediaKind,
+			Width:             meta.Width,
+			Height:            meta.Height,
+			Duration:          meta.Duration,
+			SupportsStreaming: meta.SupportsStreaming,
+			Title:             meta.AudioTitle,
+			Performer:         meta.AudioPerformer,
+		}
+	}
+
+	return info
+}
+
+// handleInfo returns file metadata as JSON
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	meta, ok := s.lookupFile(w, r, strings.TrimPrefix(r.URL.Path, "/info/"))
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.newFileInfo(meta))
+}
+
+// handlePage renders a landing page with a player and OpenGraph tags for link previews
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	meta, ok := s.lookupFile(w, r, strings.TrimPrefix(r.URL.Path, "/file/"))
+	if !ok {
+		return
+	}
+
+	info := s.newFileInfo(meta)
+	data := struct {
+		*FileInfo
+		Size     string
+		Duration string
+		IsVideo  bool
+		IsAudio  bool
+	}{
+		FileInfo: info,
+		Size:     telegram.FormatFileSize(meta.FileSize),
+		IsVideo:  strings.HasPrefix(meta.MimeType, "video/"),
+		IsAudio:  strings.HasPrefix(meta.MimeType, "audio/"),
+	}
+	if meta.Duration > 0 {
+		data.Duration = telegram.FormatDuration(meta.Duration)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pageTemplate.Execute(w, data); err != nil {
+		log.Printf("Error rendering file page: %v", err)
+	}
+}
+
+// lookupFile loads a file by link ID, writing an error response if it can't be served.
+// Expired links are gone, and password-protected ones need the password as a "password"
+// query parameter or HTTP Basic auth password.
+func (s *Server) lookupFile(w http.ResponseWriter, r *http.Request, linkID string) (*storage.FileMetadata, bool) {
+	linkID = strings.TrimSpace(linkID)
+	if linkID == "" {
+		http.Error(w, "Invalid link", http.StatusBadRequest)
+		return nil, false
+	}
+
+	meta, err := s.storage.GetFileByLink(linkID)
+	if err != nil {
+		log.Printf("Error getting file metadata: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if meta == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if meta.Expired() {
+		http.Error(w, "Link expired", http.StatusGone)
+		return nil, false
+	}
+
+	if meta.PasswordHash != "" && !checkPassword(r, meta) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Protected file", charset="UTF-8"`)
+		http.Error(w, "Password required", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return meta, true
+}
+
+// checkPassword reports whether a request carries the file's download password
+func checkPassword(r *http.Request, meta *storage.FileMetadata) bool {
+	password := r.URL.Query().Get("password")
+	if password == "" {
+		_, password, _ = r.BasicAuth()
+	}
+	if password == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(meta.PasswordHash), []byte(password)) == nil
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.FileName}}</title>
+<meta property="og:title" content="{{.FileName}}">
+<meta property="og:description" content="{{.Size}}{{with .Duration}} · {{.}}{{end}}">
+{{with .ThumbURL}}<meta property="og:image" content="{{.}}">{{end}}
+{{if .IsVideo}}<meta property="og:video" content="{{.StreamURL}}">
+<meta property="og:video:type" content="{{.MimeType}}">{{end}}
+{{with .Media}}{{if .Width}}<meta property="og:video:width" content="{{.Width}}">
+<meta property="og:video:height" content="{{.Height}}">{{end}}{{end}}
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; }
+video, audio, img { max-width: 100%; }
+</style>
+</head>
+<body>
+<h1>{{.FileName}}</h1>
+{{if .IsVideo}}<video controls preload="metadata" src="{{.StreamURL}}"{{with .ThumbURL}} poster="{{.}}"{{end}}></video>
+{{else if .IsAudio}}{{with .ThumbURL}}<img src="{{.}}" alt="">{{end}}
+<audio controls preload="metadata" src="{{.StreamURL}}"></audio>
+{{else}}{{with .ThumbURL}}<img src="{{.}}" alt="">{{end}}{{end}}
+<ul>
+<li>Size: {{.Size}}</li>
+<li>Type: {{.MimeType}}</li>
+{{with .Media}}{{if .Width}}<li>Resolution: {{.Width}}×{{.Height}}</li>{{end}}
+{{if .Performer}}<li>Performer: {{.Performer}}</li>{{end}}
+{{if .Title}}<li>Title: {{.Title}}</li>{{end}}{{end}}
+{{with .Duration}}<li>Duration: {{.}}</li>{{end}}
+</ul>
+<p><a href="{{.DownloadURL}}">Download</a> · <a href="{{.StreamURL}}">Stream</a></p>
+</body>
+</html>
+`))
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telebot_http_requests_total",
+		Help: "HTTP requests by route and status.",
+	}, []string{"route", "status"})
+	bytesStreamed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telebot_bytes_streamed_total",
+		Help: "File bytes sent to downloads and streams.",
+	})
+	downloadsInProgress = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telebot_active_downloads",
+		Help: "Downloads and streams in progress.",
+	})
+
+	throttleTransfers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telebot_throttle_transfers",
+		Help: "Downloads in progress through the download throttle.",
+	})
+	throttleBuckets = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "telebot_throttle_buckets",
+		Help: "Token buckets shared by downloads in progress, by kind of limit.",
+	}, []string{"scope"})
+	throttleRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "telebot_throttle_rate_bytes_per_second",
+		Help: "Download rate limits by kind of limit, 0 for unlimited.",
+	}, []string{"scope"})
+	throttleTierRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "telebot_throttle_tier_rate_bytes_per_second",
+		Help: "Download rate limit of one owner's files by tier, 0 for unlimited.",
+	}, []string{"tier"})
+	throttleHeldBack = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telebot_throttle_held_back_total",
+		Help: "Downloads that had to wait for a rate limit at least once, by the kind of limit.",
+	}, []string{"scope"})
+	throttleWaitSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telebot_throttle_wait_seconds_total",
+		Help: "Seconds downloads waited for rate limits, by the kind of limit.",
+	}, []string{"scope"})
+	throttleDelayedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telebot_throttle_delayed_bytes_total",
+		Help: "Bytes written only after waiting for a rate limit, by the kind of limit.",
+	}, []string{"scope"})
+
+	metricsHandler = promhttp.Handler()
+)
+
+// SetMetricsToken requires a bearer token for /metrics; without one it's open
+func (s *Server) SetMetricsToken(token string) {
+	s.metricsToken = token
+}
+
+// handleMetrics serves the metrics to Prometheus
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metricsToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.metricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Invalid metrics token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	metricsHandler.ServeHTTP(w, r)
+}
+
+// instrument counts the requests handled by mux by the pattern they matched and their status
+func instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// statusRecorder remembers the status a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+
+	"tele-bot/storage"
+)
+
+// handlePlaylist serves a collection's audio and video files as an extended M3U playlist
+func (s *Server) handlePlaylist(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/playlist/")
+
+	// The playlist is served with the extension it was asked for
+	ext := path.Ext(name)
+	var contentType string
+	switch ext {
+	case ".m3u8":
+		contentType = "application/vnd.apple.mpegurl"
+	case ".m3u":
+		contentType = "audio/x-mpegurl"
+	default:
+		http.Error(w, "Playlist must end in .m3u8 or .m3u", http.StatusNotFound)
+		return
+	}
+	name = strings.TrimSuffix(name, ext)
+
+	if name == "" {
+		http.Error(w, "Invalid collection", http.StatusBadRequest)
+		return
+	}
+
+	files, err := s.storage.ListCollection(name)
+	if err != nil {
+		log.Printf("Error listing collection: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString(fmt.Sprintf("#PLAYLIST:%s\n", name))
+
+	entries := 0
+	for _, meta := range files {
+		// Protected and expired links can't be opened from a playlist
+		if !isPlayable(meta) || meta.PasswordHash != "" || meta.Expired() {
+			continue
+		}
+		// -1 tells players the duration is unknown
+		duration := -1
+		if meta.Duration > 0 {
+			duration = int(math.Round(meta.Duration))
+		}
+		b.WriteString(fmt.Sprintf("#EXTINF:%d,%s\n", duration, playlistTitle(meta)))
+		b.WriteString(fmt.Sprintf("%s/stream/%s\n", s.baseURL, meta.LinkID))
+		entries++
+	}
+
+	if entries == 0 {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s%s\"", name, ext))
+	w.Write([]byte(b.String()))
+}
+
+// isPlayable reports whether a file belongs in a media playlist
+func isPlayable(meta *storage.FileMetadata) bool {
+	switch meta.MediaKind {
+	case storage.MediaVideo, storage.MediaAudio, storage.MediaVoice, storage.MediaRound:
+		return true
+	}
+	return strings.HasPrefix(meta.MimeType, "video/") || strings.HasPrefix(meta.MimeType, "audio/")
+}
+
+// playlistTitle returns the "#EXTINF" title of a file: "Performer - Title" for tagged audio, the file name otherwise
+func playlistTitle(meta *storage.FileMetadata) string {
+	title := meta.FileName
+	if meta.AudioTitle != "" {
+		title = meta.AudioTitle
+		if meta.AudioPerformer != "" {
+			title = meta.AudioPerformer + " - " + meta.AudioTitle
+		}
+	}
+	// A newline would end the directive early
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(title)
+}
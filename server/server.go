@@ -1,42 +1,137 @@
 package server
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
+	"github.com/gotd/td/telegram/thumbnail"
 	"github.com/gotd/td/tg"
 
 	"tele-bot/storage"
 	"tele-bot/telegram"
 )
 
+const (
+	// genericMimeType is what Telegram reports when the uploader's client didn't know the type
+	genericMimeType = "application/octet-stream"
+
+	// sniffLen is the number of bytes http.DetectContentType considers
+	sniffLen = 512
+)
+
 // Server handles HTTP requests for file downloads
 type Server struct {
-	storage *storage.Storage
-	api     *tg.Client // Pooled API for downloads
-	baseURL string
+	storage    *storage.Storage
+	api        *tg.Client // Pooled API for downloads
+	baseURL    string
+	uploader   *telegram.Uploader // nil when uploads are disabled
+	refresher  *telegram.Refresher
+	workers    *telegram.Workers    // Extra bots downloads are spread over, nil for the main bot only
+	scheduler  *telegram.Scheduler  // Shares chunk requests fairly between downloads, nil for no limit
+	trustProxy bool                 // Take client IPs from X-Forwarded-For
+	cache      *telegram.ChunkCache // Chunks shared between downloads, nil to disable
+	disk       *telegram.DiskCache  // Verified chunks kept on disk, nil to disable
+	verify     bool                 // Check downloaded chunks against Telegram's file hashes
+	throttle   *Throttle            // Download rate limits, nil for none
+	quotas     storage.Quotas
+	access     storage.AccessPolicy // The bot's access rules, which API tokens are held to too
+	tusLocks   tusLocks
+
+	// Download totals for /stats
+	activeDownloads atomic.Int64
+	bytesServed     atomic.Int64
+
+	// Admin endpoints, disabled without a token
+	adminToken string
+	botAdmins  map[int64]bool // Users whose API tokens may read the audit log
+
+	metricsToken string // Bearer token for /metrics, empty for open access
+	client       *telegram.Client
 }
 
 // New creates a new HTTP server
 func New(storage *storage.Storage, api *tg.Client, baseURL string) *Server {
 	return &Server{
-		storage: storage,
-		api:     api,
-		baseURL: baseURL,
+		storage:   storage,
+		api:       api,
+		baseURL:   baseURL,
+		refresher: telegram.NewRefresher(api, storage),
 	}
 }
 
+// SetUploader enables the upload endpoints, which post files through the given uploader
+func (s *Server) SetUploader(uploader *telegram.Uploader) {
+	s.uploader = uploader
+}
+
+// SetWorkers spreads download chunk requests over a pool of bot accounts
+func (s *Server) SetWorkers(workers *telegram.Workers) {
+	s.workers = workers
+}
+
+// SetScheduler caps the chunk requests in flight across all downloads
+func (s *Server) SetScheduler(scheduler *telegram.Scheduler) {
+	s.scheduler = scheduler
+}
+
+// SetTrustProxy tells clients apart by X-Forwarded-For instead of the connection's address
+func (s *Server) SetTrustProxy(trustProxy bool) {
+	s.trustProxy = trustProxy
+}
+
+// SetCache makes downloads share chunks through an in-memory cache
+func (s *Server) SetCache(cache *telegram.ChunkCache) {
+	s.cache = cache
+}
+
+// SetVerify checks every chunk downloaded from Telegram against the file's hashes, and records
+// the SHA-256 of files downloaded in full for the Repr-Digest header
+func (s *Server) SetVerify(verify bool) {
+	s.verify = verify
+}
+
+// SetThrottle rate-limits downloads
+func (s *Server) SetThrottle(throttle *Throttle) {
+	s.throttle = throttle
+}
+
+// SetQuotas limits the links users can create over the API. Downloads of files whose owner used
+// up their monthly egress are throttled to the throttle's over-quota limit.
+func (s *Server) SetQuotas(quotas storage.Quotas) {
+	s.quotas = quotas
+}
+
+// SetDiskCache serves popular files from a disk cache, filled with verified chunks as they're downloaded
+func (s *Server) SetDiskCache(disk *telegram.DiskCache) {
+	s.disk = disk
+}
+
 // Start begins the HTTP server
 func (s *Server) Start(port int) error {
 	http.HandleFunc("/download/", s.handleDownload)
+	http.HandleFunc("/stream/", s.handleStream)
+	http.HandleFunc("/thumb/", s.handleThumb)
+	http.HandleFunc("/file/", s.handlePage)
+	http.HandleFunc("/info/", s.handleInfo)
+	http.HandleFunc("/playlist/", s.handlePlaylist)
+	s.registerAPI()
+	s.registerAdmin()
 	http.HandleFunc("/health", s.handleHealth)
+	http.HandleFunc("/metrics", s.handleMetrics)
 
 	addr := fmt.Sprintf(":%d", port)
 	log.Printf("HTTP server starting on %s", addr)
-	return http.ListenAndServe(addr, nil)
+	return http.ListenAndServe(addr, instrument(http.DefaultServeMux))
 }
 
 // handleHealth is a simple health check endpoint
@@ -46,81 +141,217 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleDownload handles file download requests
+// Appending ?inline=1 serves the file for in-browser playback instead of as an attachment
 func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
-	// Extract link ID from URL
-	path := strings.TrimPrefix(r.URL.Path, "/download/")
-	linkID := strings.TrimSpace(path)
+	linkID := strings.TrimPrefix(r.URL.Path, "/download/")
+	inline := r.URL.Query().Get("inline") == "1"
+	s.serveFile(w, r, linkID, inline)
+}
 
-	if linkID == "" {
-		http.Error(w, "Invalid link", http.StatusBadRequest)
-		return
-	}
+// handleStream serves files inline so media players can seek inside them
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	linkID := strings.TrimPrefix(r.URL.Path, "/stream/")
+	s.serveFile(w, r, linkID, true)
+}
 
+// serveFile streams a stored file with Range support, either as an attachment or inline.
+// Ranges, conditional requests and HEAD are handled by http.ServeContent over a seekable telegram.File.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, linkID string, inline bool) {
 	// Get file metadata from database
-	meta, err := s.storage.GetFileByLink(linkID)
-	if err != nil {
-		log.Printf("Error getting file metadata: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	meta, ok := s.lookupFile(w, r, linkID)
+	if !ok {
 		return
 	}
 
-	if meta == nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
+	// Turn new downloads away while the queue is full, before any headers are sent
+	if s.scheduler != nil && r.Method != http.MethodHead {
+		if retry, err := s.scheduler.Admit(); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())))
+			http.Error(w, "Too many downloads in progress, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	ctx := r.Context()
+
+	// Set Content-Disposition to suggest filename
+	dispositionType := "attachment"
+	if inline {
+		dispositionType = "inline"
+	}
+	disposition := fmt.Sprintf("%s; filename=\"%s\"", dispositionType, meta.FileName)
+	w.Header().Set("Content-Disposition", disposition)
+
+	// Digest of the whole file (RFC 9530), also for range requests
+	if len(meta.SHA256) > 0 {
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(meta.SHA256)+":")
+	}
+
+	log.Printf("📥 Download request: %s range=%q, size=%d, inline=%t", r.Method, r.Header.Get("Range"), meta.FileSize, inline)
+
+	file := telegram.NewFile(ctx, s.api, meta.FileID, meta.AccessHash, meta.FileReference, meta.FileSize)
+	file.OnReferenceExpired(s.refreshFunc(meta))
+	if s.workers != nil {
+		file.UseWorkers(s.workers, meta)
+	}
+	if s.scheduler != nil {
+		file.Schedule(s.scheduler, s.clientIP(r), meta.LinkID)
+	}
+	if s.cache != nil {
+		file.UseCache(s.cache)
 	}
+	if s.disk != nil {
+		file.UseDiskCache(s.disk)
+	}
+	if s.verify {
+		file.Verify()
+		if len(meta.SHA256) == 0 {
+			// Only fires when the whole file is read in order
+			file.OnDigest(s.digestFunc(meta))
+		}
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", s.contentType(r, meta, file))
+
+	if s.throttle != nil {
+		throttled, done := s.throttle.Writer(ctx, w, s.clientIP(r), meta.LinkID, meta.OwnerID, s.overEgressQuota(meta.OwnerID))
+		defer done()
+		w = throttled
+	}
+
+	// Count what's sent for the service totals, the audit log and the owner's egress
+	s.activeDownloads.Add(1)
+	downloadsInProgress.Inc()
+	counter := &countingWriter{ResponseWriter: w}
+	defer s.finishDownload(r, meta, counter)
+	w = counter
+
+	http.ServeContent(w, r, meta.FileName, meta.CreatedAt, file)
+}
+
+// digestFunc returns a callback saving the SHA-256 of a file once it has been downloaded in full
+func (s *Server) digestFunc(meta *storage.FileMetadata) func(sum []byte) {
+	return func(sum []byte) {
+		if err := s.storage.UpdateFileDigest(meta.FileID, sum); err != nil {
+			log.Printf("⚠️ Failed to save SHA-256 of %s: %v", meta.LinkID, err)
+			return
+		}
+		log.Printf("🔏 Saved SHA-256 of %s: %x", meta.LinkID, sum)
+	}
+}
 
-	// Parse Range header
-	rangeHeader := r.Header.Get("Range")
-	httpRange, err := ParseRange(rangeHeader, meta.FileSize)
+// clientIP returns the address a request came from
+func (s *Server) clientIP(r *http.Request) string {
+	if s.trustProxy {
+		// The proxy appends the address it saw, so the first entry is the original client
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		http.Error(w, "Invalid range", http.StatusRequestedRangeNotSatisfiable)
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", meta.FileSize))
-		return
+		return r.RemoteAddr
 	}
+	return host
+}
 
-	// Set response headers
-	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Type", meta.MimeType)
+// contentType returns the MIME type to serve a file with.
+// Telegram reports many uploads as application/octet-stream, which browsers refuse to play,
+// so in that case the type is sniffed from the first bytes and saved for later requests.
+// HEAD requests only guess from the file name, they shouldn't cost a download.
+func (s *Server) contentType(r *http.Request, meta *storage.FileMetadata, file *telegram.File) string {
+	if meta.MimeSniffed || (meta.MimeType != "" && meta.MimeType != genericMimeType) {
+		return meta.MimeType
+	}
+	if r.Method == http.MethodHead {
+		return typeByExtension(meta.FileName)
+	}
 
-	// Set Content-Disposition to suggest filename
-	disposition := fmt.Sprintf("attachment; filename=\"%s\"", meta.FileName)
-	w.Header().Set("Content-Disposition", disposition)
+	detected, err := sniffContentType(file)
+	if err != nil {
+		// Try again on the next request
+		log.Printf("⚠️ Failed to read file head for MIME sniffing: %v", err)
+		return typeByExtension(meta.FileName)
+	}
+	if detected == genericMimeType {
+		// Fall back to the file extension when the content is not recognised
+		detected = typeByExtension(meta.FileName)
+	}
 
-	// Determine status code and set appropriate headers
-	if rangeHeader != "" && (httpRange.Start != 0 || httpRange.End != meta.FileSize-1) {
-		// Partial content
-		w.Header().Set("Content-Range", httpRange.ContentRange(meta.FileSize))
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", httpRange.Length))
-		w.WriteHeader(http.StatusPartialContent)
+	// Saved even when nothing better than application/octet-stream was found, so it isn't sniffed again
+	if err := s.storage.UpdateMimeType(meta.LinkID, detected); err != nil {
+		log.Printf("⚠️ Failed to save sniffed MIME type: %v", err)
 	} else {
-		// Full content
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", meta.FileSize))
-		w.WriteHeader(http.StatusOK)
+		log.Printf("🔍 Sniffed MIME type for %s: %s", meta.LinkID, detected)
 	}
+	return detected
+}
 
-	// Stream file from Telegram using TelegramReader
-	ctx := r.Context()
-	log.Printf("📥 Download request: start=%d, end=%d, length=%d", httpRange.Start, httpRange.End, httpRange.Length)
-
-	// Create a TelegramReader for the requested byte range
-	reader := telegram.NewTelegramReader(
-		ctx,
-		s.api,
-		meta.FileID,
-		meta.AccessHash,
-		meta.FileReference,
-		httpRange.Start,
-		httpRange.End,
-	)
-	defer reader.Close()
-
-	// Stream to HTTP response
-	_, err = io.Copy(w, reader)
-	if err != nil {
-		log.Printf("Error streaming file: %v", err)
-		// Can't send error response as headers already sent
+// sniffContentType detects a file's MIME type from its first bytes, read through the same
+// bots, scheduler and caches as the download itself
+func sniffContentType(file *telegram.File) (string, error) {
+	if file.Size() <= 0 {
+		return genericMimeType, nil
+	}
+
+	head := make([]byte, min(sniffLen, file.Size()))
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// typeByExtension guesses a MIME type from a file name
+func typeByExtension(name string) string {
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+		return byExt
+	}
+	return genericMimeType
+}
+
+// handleThumb serves the thumbnail Telegram generated for a document
+func (s *Server) handleThumb(w http.ResponseWriter, r *http.Request) {
+	meta, ok := s.lookupFile(w, r, strings.TrimPrefix(r.URL.Path, "/thumb/"))
+	if !ok {
 		return
 	}
+
+	var data []byte
+	var err error
+	if meta.ThumbType != "" {
+		data, err = telegram.DownloadThumb(r.Context(), s.api, meta.FileID, meta.AccessHash, meta.FileReference, meta.ThumbType, s.refreshFunc(meta))
+		if err != nil {
+			log.Printf("⚠️ Failed to download thumbnail: %v", err)
+		}
+	}
+
+	// Fall back to the inline stripped thumbnail
+	if len(data) == 0 && len(meta.ThumbStripped) > 0 {
+		data, err = thumbnail.Expand(meta.ThumbStripped)
+		if err != nil {
+			log.Printf("⚠️ Failed to expand stripped thumbnail: %v", err)
+		}
+	}
+
+	if len(data) == 0 {
+		http.Error(w, "Thumbnail not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+}
+
+// refreshFunc returns the file reference refresher for a file
+func (s *Server) refreshFunc(meta *storage.FileMetadata) telegram.ReferenceRefresher {
+	return func(ctx context.Context) ([]byte, error) {
+		return s.refresher.Refresh(ctx, meta)
+	}
 }
 
 // GenerateDownloadLink creates a download URL for a file
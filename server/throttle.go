@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTier is the tier of owners not assigned to one
+	DefaultTier = "default"
+
+	// throttleSlice is the most written between two rate checks
+	throttleSlice = 32 * 1024
+)
+
+// ThrottleLimits are download rate limits in KiB/s, 0 meaning unlimited
+type ThrottleLimits struct {
+	Global     int64            `json:"global"`      // All downloads together
+	PerIP      int64            `json:"per_ip"`      // All downloads of one client IP
+	PerLink    int64            `json:"per_link"`    // All downloads of one link
+	Tiers      map[string]int64 `json:"tiers"`       // All downloads of one owner's files, by the owner's tier
+	OwnerTiers map[int64]string `json:"owner_tiers"` // Owner tiers, others are in DefaultTier
+	OverQuota  int64            `json:"over_quota"`  // All downloads of one owner who used up their monthly egress
+}
+
+// ThrottleStats counts the downloads slowed down by each limit
+type ThrottleStats struct {
+	Transfers int64                    `json:"transfers"` // Downloads in progress
+	Throttled map[string]ThrottleScope `json:"throttled"` // By limit: global, per_ip, per_link, tier, over_quota
+}
+
+// ThrottleScope counts how often one kind of limit held downloads back
+type ThrottleScope struct {
+	Transfers    int64   `json:"transfers"` // Downloads that had to wait at least once
+	WaitSeconds  float64 `json:"wait_seconds"`
+	DelayedBytes int64   `json:"delayed_bytes"` // Bytes written only after waiting
+}
+
+// Throttle rate-limits downloads with token buckets, globally, per client IP, per link and
+// per file owner. Buckets are shared by a key's concurrent downloads and dropped when the
+// last one finishes.
+type Throttle struct {
+	mu        sync.Mutex
+	limits    ThrottleLimits
+	global    *tokenBucket
+	buckets   map[bucketKey]*sharedBucket
+	transfers int64
+	stats     map[string]*ThrottleScope
+}
+
+// bucketKey identifies the bucket of one IP, link or owner
+type bucketKey struct {
+	scope string // per_ip, per_link, tier or over_quota
+	id    string
+}
+
+// sharedBucket is a token bucket used by the downloads of one IP, link or owner
+type sharedBucket struct {
+	*tokenBucket
+	key     bucketKey
+	ownerID int64 // For tier buckets
+	users   int
+}
+
+// NewThrottle creates a throttle with the given limits
+func NewThrottle(limits ThrottleLimits) *Throttle {
+	t := &Throttle{
+		global:  &tokenBucket{last: time.Now()},
+		buckets: make(map[bucketKey]*sharedBucket),
+		stats:   make(map[string]*ThrottleScope),
+	}
+	t.SetLimits(limits)
+	t.global.tokens = float64(t.global.rate)
+	return t
+}
+
+// Limits returns the current limits
+func (t *Throttle) Limits() ThrottleLimits {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limits
+}
+
+// SetLimits replaces the limits. Downloads in progress pick them up immediately.
+func (t *Throttle) SetLimits(limits ThrottleLimits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.limits = limits
+	t.global.setRate(limits.Global * 1024)
+	for _, b := range t.buckets {
+		b.setRate(t.rateLocked(b))
+	}
+
+	throttleRate.WithLabelValues("global").Set(float64(limits.Global * 1024))
+	throttleRate.WithLabelValues("per_ip").Set(float64(limits.PerIP * 1024))
+	throttleRate.WithLabelValues("per_link").Set(float64(limits.PerLink * 1024))
+	throttleRate.WithLabelValues("over_quota").Set(float64(limits.OverQuota * 1024))
+	throttleTierRate.Reset()
+	for tier, rate := range limits.Tiers {
+		throttleTierRate.WithLabelValues(tier).Set(float64(rate * 1024))
+	}
+}
+
+// Stats returns how much each limit has slowed downloads down
+func (t *Throttle) Stats() ThrottleStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := ThrottleStats{Transfers: t.transfers, Throttled: make(map[string]ThrottleScope)}
+	for scope, s := range t.stats {
+		stats.Throttled[scope] = *s
+	}
+	return stats
+}
+
+// Writer wraps w so everything written through it is rate-limited for a download of link by
+// clientIP of a file owned by ownerID, who may be over their egress quota.
+// The returned function must be called when the download ends.
+func (t *Throttle) Writer(ctx context.Context, w http.ResponseWriter, clientIP, link string, ownerID int64, overQuota bool) (http.ResponseWriter, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	owner := strconv.FormatInt(ownerID, 10)
+	buckets := []*sharedBucket{
+		t.bucketLocked(bucketKey{"per_ip", clientIP}, 0),
+		t.bucketLocked(bucketKey{"per_link", link}, 0),
+		t.bucketLocked(bucketKey{"tier", owner}, ownerID),
+	}
+	if overQuota {
+		buckets = append(buckets, t.bucketLocked(bucketKey{"over_quota", owner}, ownerID))
+	}
+	t.transfers++
+	throttleTransfers.Inc()
+
+	tw := &throttledWriter{ResponseWriter: w, ctx: ctx, throttle: t, buckets: buckets}
+	return tw, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.transfers--
+		throttleTransfers.Dec()
+		for _, b := range buckets {
+			if b.users--; b.users == 0 {
+				delete(t.buckets, b.key)
+				throttleBuckets.WithLabelValues(b.key.scope).Dec()
+			}
+		}
+	}
+}
+
+// bucketLocked returns the bucket for key, creating it for the first download using it
+func (t *Throttle) bucketLocked(key bucketKey, ownerID int64) *sharedBucket {
+	b, ok := t.buckets[key]
+	if !ok {
+		b = &sharedBucket{tokenBucket: &tokenBucket{last: time.Now()}, key: key, ownerID: ownerID}
+		b.setRate(t.rateLocked(b))
+		b.tokens = float64(b.rate)
+		t.buckets[key] = b
+		throttleBuckets.WithLabelValues(key.scope).Inc()
+	}
+	b.users++
+	return b
+}
+
+// rateLocked returns the limit of a bucket in bytes per second
+func (t *Throttle) rateLocked(b *sharedBucket) int64 {
+	switch b.key.scope {
+	case "per_ip":
+		return t.limits.PerIP * 1024
+	case "per_link":
+		return t.limits.PerLink * 1024
+	case "over_quota":
+		return t.limits.OverQuota * 1024
+	default:
+		tier, ok := t.limits.OwnerTiers[b.ownerID]
+		if !ok {
+			tier = DefaultTier
+		}
+		return t.limits.Tiers[tier] * 1024
+	}
+}
+
+// waited records that a download was held back by a limit before writing n bytes
+func (t *Throttle) waited(scope string, wait time.Duration, n int, first bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stats[scope]
+	if !ok {
+		s = &ThrottleScope{}
+		t.stats[scope] = s
+	}
+	if first {
+		s.Transfers++
+		throttleHeldBack.WithLabelValues(scope).Inc()
+	}
+	s.WaitSeconds += wait.Seconds()
+	s.DelayedBytes += int64(n)
+	throttleWaitSeconds.WithLabelValues(scope).Add(wait.Seconds())
+	throttleDelayedBytes.WithLabelValues(scope).Add(float64(n))
+}
+
+// throttledWriter is a ResponseWriter that waits for tokens before every write
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	throttle *Throttle
+	buckets  []*sharedBucket
+	waitedIn map[string]bool // Scopes that have held this download back
+}
+
+// Write writes p in slices, each after every applicable bucket has tokens for it
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := min(len(p)-written, throttleSlice)
+
+		// Reserve from every bucket at once, then wait for the slowest
+		wait := w.throttle.global.reserve(n)
+		scope := "global"
+		for _, b := range w.buckets {
+			if d := b.reserve(n); d > wait {
+				wait, scope = d, b.key.scope
+			}
+		}
+
+		if wait > 0 {
+			if w.waitedIn == nil {
+				w.waitedIn = make(map[string]bool)
+			}
+			w.throttle.waited(scope, wait, n, !w.waitedIn[scope])
+			w.waitedIn[scope] = true
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return written, w.ctx.Err()
+			}
+		}
+
+		m, err := w.ResponseWriter.Write(p[written : written+n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// tokenBucket allows rate bytes per second with bursts of up to a second's worth.
+// Reservations may drive the tokens negative; the caller then waits until they're paid back.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   int64 // Bytes per second, 0 for unlimited
+	tokens float64
+	last   time.Time
+}
+
+// setRate changes the bucket's rate
+func (b *tokenBucket) setRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked()
+	b.rate = rate
+	b.tokens = min(b.tokens, float64(rate))
+}
+
+// reserve takes n tokens and returns how long to wait before using them
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refillLocked()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
+
+// refillLocked adds the tokens earned since the last refill
+func (b *tokenBucket) refillLocked() {
+	now := time.Now()
+	if b.rate > 0 {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(b.rate), float64(b.rate))
+	}
+	b.last = now
+}
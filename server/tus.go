@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"tele-bot/storage"
+	"tele-bot/telegram"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusPath       = "/api/v1/tus/"
+)
+
+// tusLocks serialises requests for the same upload. A lock is dropped once nobody holds or waits for it.
+type tusLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tusLock
+}
+
+// tusLock is the lock of one upload
+type tusLock struct {
+	sync.Mutex
+	refs int // Requests holding or waiting for the lock
+}
+
+// lock acquires the lock of an upload and returns its release function
+func (l *tusLocks) lock(id string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*tusLock)
+	}
+	m, ok := l.locks[id]
+	if !ok {
+		m = &tusLock{}
+		l.locks[id] = m
+	}
+	m.refs++
+	l.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+
+		l.mu.Lock()
+		m.refs--
+		if m.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// handleTus implements the tus 1.0 resumable upload protocol with the creation, termination and expiration extensions.
+// Every completed 512 KiB part is sent to Telegram right away, so only the part in progress is stored.
+func (s *Server) handleTus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	// Capability discovery doesn't need a token
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(telegram.MaxUploadSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		writeAPIError(w, http.StatusPreconditionFailed, "unsupported_version", "Only tus 1.0.0 is supported")
+		return
+	}
+
+	s.apiAuth(s.handleTusAuthed)(w, r)
+}
+
+// handleTusAuthed dispatches authenticated tus requests
+func (s *Server) handleTusAuthed(w http.ResponseWriter, r *http.Request, userID int64) {
+	if s.uploader == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "uploads_disabled", "Uploads need STORAGE_CHANNEL_ID to be configured")
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, tusPath)
+	if id == "" {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use POST to create an upload")
+			return
+		}
+		s.tusCreate(w, r, userID)
+		return
+	}
+
+	// Only lock uploads the caller owns, then load them again as the request before may have changed them
+	if _, ok := s.loadTusUpload(w, id, userID); !ok {
+		return
+	}
+	unlock := s.tusLocks.lock(id)
+	defer unlock()
+
+	upload, ok := s.loadTusUpload(w, id, userID)
+	if !ok {
+		return
+	}
+	if upload.Expired() {
+		if err := s.storage.DeleteTusUpload(upload.ID); err != nil {
+			log.Printf("Error deleting tus upload: %v", err)
+		}
+		writeAPIError(w, http.StatusGone, "expired", "Upload expired")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		setTusExpiresHeader(w, upload)
+		s.setTusLinkHeaders(w, upload)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodPatch:
+		s.tusPatch(w, r, upload)
+
+	case http.MethodDelete:
+		if err := s.storage.DeleteTusUpload(upload.ID); err != nil {
+			log.Printf("Error deleting tus upload: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+			return
+		}
+		// Parts already sent to Telegram are discarded by Telegram once they go unused
+		log.Printf("🗑 tus upload %s terminated", upload.ID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use HEAD, PATCH or DELETE")
+	}
+}
+
+// loadTusUpload returns an upload of userID, answering the request itself if there's none
+func (s *Server) loadTusUpload(w http.ResponseWriter, id string, userID int64) (*storage.TusUpload, bool) {
+	upload, err := s.storage.GetTusUpload(id)
+	if err != nil {
+		log.Printf("Error loading tus upload: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+		return nil, false
+	}
+	if upload == nil || upload.UserID != userID {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Upload not found")
+		return nil, false
+	}
+	return upload, true
+}
+
+// tusCreate starts a new upload (creation extension)
+func (s *Server) tusCreate(w http.ResponseWriter, r *http.Request, userID int64) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_length", "Upload-Length must be a positive integer")
+		return
+	}
+	if length > telegram.MaxUploadSize {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "too_large", "Files may be at most 2000 MiB")
+		return
+	}
+
+	metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	fileName := strings.TrimSpace(metadata["filename"])
+	if fileName == "" || strings.ContainsAny(fileName, "/\\\"\r\n") {
+		writeAPIError(w, http.StatusBadRequest, "invalid_file_name", "Upload-Metadata must include a valid filename")
+		return
+	}
+	mimeType := metadata["filetype"]
+	if mimeType == "" {
+		mimeType = uploadMimeType(r, fileName)
+	}
+	if !s.checkLinkQuota(w, userID, length) {
+		return
+	}
+
+	uploadID, err := telegram.NewUploadID()
+	if err != nil {
+		log.Printf("Error generating upload ID: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+		return
+	}
+
+	upload := &storage.TusUpload{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		UploadID: uploadID,
+		FileName: fileName,
+		MimeType: mimeType,
+		Length:   length,
+	}
+	if err := s.storage.CreateTusUpload(upload); err != nil {
+		log.Printf("Error creating tus upload: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "Internal server error")
+		return
+	}
+
+	log.Printf("📤 tus upload %s created by user %d: %s (%s)", upload.ID, userID, fileName, telegram.FormatFileSize(length))
+	w.Header().Set("Location", s.baseURL+tusPath+upload.ID)
+	setTusExpiresHeader(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// tusPatch appends the request body to an upload at Upload-Offset
+func (s *Server) tusPatch(w http.ResponseWriter, r *http.Request, upload *storage.TusUpload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "invalid_content_type", "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		writeAPIError(w, http.StatusConflict, "offset_mismatch", "Upload-Offset doesn't match the current offset")
+		return
+	}
+
+	if upload.LinkID == "" {
+		body := http.MaxBytesReader(w, r.Body, upload.Length-upload.Offset)
+		if err := s.receiveTusData(r.Context(), upload, body); err != nil {
+			log.Printf("⚠️ tus upload %s interrupted at offset %d: %v", upload.ID, upload.Offset, err)
+			if upload.Offset != offset {
+				// Progress was saved; the client resumes from the new offset
+				w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			}
+			writeAPIError(w, http.StatusBadGateway, "upload_failed", "Failed to upload part to Telegram")
+			return
+		}
+	}
+
+	if upload.Offset == upload.Length && upload.LinkID == "" {
+		input := telegram.InputFileFor(upload.UploadID, upload.Length, upload.FileName)
+		meta, err := s.publishUpload(r.Context(), upload.UserID, input, upload.FileName, upload.MimeType)
+		if err != nil {
+			log.Printf("❌ Publish of tus upload %s failed: %v", upload.ID, err)
+			writeAPIError(w, http.StatusBadGateway, "upload_failed", "Failed to post file to the storage channel")
+			return
+		}
+		upload.LinkID = meta.LinkID
+		if err := s.storage.UpdateTusUpload(upload); err != nil {
+			log.Printf("Error saving tus upload: %v", err)
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	setTusExpiresHeader(w, upload)
+	s.setTusLinkHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// receiveTusData reads body into Telegram parts, saving each part as soon as it's complete.
+// The incomplete tail is stored so the next PATCH can continue it.
+// The body ending early is not an error - the client sends the rest in a later PATCH.
+func (s *Server) receiveTusData(ctx context.Context, upload *storage.TusUpload, body io.Reader) error {
+	partIndex := int((upload.Offset - int64(len(upload.Pending))) / telegram.UploadPartSize)
+
+	for upload.Offset < upload.Length || len(upload.Pending) > 0 {
+		partStart := int64(partIndex) * telegram.UploadPartSize
+		partLen := upload.Length - partStart
+		if partLen > telegram.UploadPartSize {
+			partLen = telegram.UploadPartSize
+		}
+
+		part := make([]byte, partLen)
+		filled := copy(part, upload.Pending)
+		n, readErr := io.ReadFull(body, part[filled:])
+		filled += n
+		upload.Offset += int64(n)
+
+		if filled < len(part) {
+			upload.Pending = part[:filled]
+			if err := s.storage.UpdateTusUpload(upload); err != nil {
+				return fmt.Errorf("failed to save upload state: %w", err)
+			}
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return readErr
+		}
+
+		// A full part - send it to Telegram, keeping it pending if that fails
+		upload.Pending = part
+		if err := s.uploader.SavePart(ctx, upload.UploadID, partIndex, upload.Length, part); err != nil {
+			if saveErr := s.storage.UpdateTusUpload(upload); saveErr != nil {
+				err = errors.Join(err, saveErr)
+			}
+			return err
+		}
+
+		upload.Pending = nil
+		partIndex++
+		if err := s.storage.UpdateTusUpload(upload); err != nil {
+			return fmt.Errorf("failed to save upload state: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// setTusExpiresHeader tells the client until when an incomplete upload can be resumed (expiration extension)
+func setTusExpiresHeader(w http.ResponseWriter, upload *storage.TusUpload) {
+	if upload.LinkID != "" {
+		return
+	}
+	w.Header().Set("Upload-Expires", upload.ExpiresAt().UTC().Format(http.TimeFormat))
+}
+
+// setTusLinkHeaders tells the client where a completed upload can be downloaded
+func (s *Server) setTusLinkHeaders(w http.ResponseWriter, upload *storage.TusUpload) {
+	if upload.LinkID == "" {
+		return
+	}
+	w.Header().Set("X-Link-ID", upload.LinkID)
+	w.Header().Set("X-Download-URL", s.GenerateDownloadLink(upload.LinkID))
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated "key base64value" pairs
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata
+}
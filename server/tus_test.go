@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+
+	"tele-bot/storage"
+	"tele-bot/telegram"
+)
+
+// partRecorder is a Telegram API that keeps the upload parts it's sent
+type partRecorder struct {
+	mu    sync.Mutex
+	parts map[int][]byte
+	fail  int // Number of upcoming part uploads to fail
+}
+
+func (p *partRecorder) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("part upload failed")
+	}
+
+	var part int
+	var data []byte
+	switch req := input.(type) {
+	case *tg.UploadSaveFilePartRequest:
+		part, data = req.FilePart, req.Bytes
+	case *tg.UploadSaveBigFilePartRequest:
+		part, data = req.FilePart, req.Bytes
+	default:
+		return errors.New("unexpected request")
+	}
+	if _, ok := p.parts[part]; ok {
+		return errors.New("part saved twice")
+	}
+	p.parts[part] = append([]byte(nil), data...)
+	output.(*tg.BoolBox).Bool = &tg.BoolTrue{}
+	return nil
+}
+
+// failPart fails the next n part uploads, each retry included
+func (p *partRecorder) failPart(n int) {
+	p.mu.Lock()
+	p.fail = n
+	p.mu.Unlock()
+}
+
+func TestReceiveTusData(t *testing.T) {
+	const part = telegram.UploadPartSize
+
+	tests := []struct {
+		name    string
+		length  int64
+		patches []int64 // Bytes sent by each PATCH
+		failAt  int     // PATCH whose part uploads all fail and is resent, -1 for none
+	}{
+		{"smaller than a part", 1000, []int64{1000}, -1},
+		{"exactly one part", part, []int64{part}, -1},
+		{"one byte over a part", part + 1, []int64{part + 1}, -1},
+		{"short tail part", 3*part + 12345, []int64{3*part + 12345}, -1},
+		{"patches on part boundaries", 3 * part, []int64{part, part, part}, -1},
+		{"resume mid-part", 2*part + 100, []int64{100, part, part}, -1},
+		{"resume across several parts", 3*part + 7, []int64{part - 1, 2, part + 3, part + 3}, -1},
+		{"one byte at a time at a boundary", part + 2, []int64{part - 1, 1, 1, 1}, -1},
+		{"tail sent alone", 2*part + 10, []int64{2 * part, 10}, -1},
+		{"failed part is kept pending", 2*part + 5, []int64{part + 20, part - 15}, 0},
+		{"big file parts", 10*1024*1024 + 3, []int64{5*part + 1, 15*part + 2}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+
+			api := &partRecorder{parts: make(map[int][]byte)}
+			s := &Server{storage: st, uploader: telegram.NewUploader(nil, tg.NewClient(api), nil, 1)}
+
+			upload := &storage.TusUpload{ID: "upload", UserID: 1, FileName: "f", MimeType: "m", Length: tt.length}
+			if err := st.CreateTusUpload(upload); err != nil {
+				t.Fatal(err)
+			}
+
+			data := make([]byte, tt.length)
+			for i := range data {
+				data[i] = byte(i * 7 % 251)
+			}
+
+			var sent int64
+			for i, n := range tt.patches {
+				// Every PATCH starts from the saved state, like a new request would
+				upload, err = st.GetTusUpload(upload.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if upload.Offset != sent {
+					t.Fatalf("patch %d: offset %d, want %d", i, upload.Offset, sent)
+				}
+
+				body := bytes.NewReader(data[sent : sent+n])
+				if i == tt.failAt {
+					api.failPart(3)
+					if err := s.receiveTusData(context.Background(), upload, body); err == nil {
+						t.Fatalf("patch %d: want an error from the failed part", i)
+					}
+
+					// Everything read is saved, including the part Telegram didn't take
+					upload, err = st.GetTusUpload(upload.ID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if upload.Offset-int64(len(upload.Pending)) != int64(len(api.parts))*part {
+						t.Fatalf("patch %d: offset %d with %d pending bytes after %d parts",
+							i, upload.Offset, len(upload.Pending), len(api.parts))
+					}
+
+					// The client resends the rest from the saved offset
+					body = bytes.NewReader(data[upload.Offset : sent+n])
+				}
+
+				if err := s.receiveTusData(context.Background(), upload, body); err != nil {
+					t.Fatalf("patch %d: %v", i, err)
+				}
+				sent += n
+			}
+
+			upload, err = st.GetTusUpload(upload.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if upload.Offset != tt.length || len(upload.Pending) != 0 {
+				t.Fatalf("offset %d with %d pending bytes, want %d with none", upload.Offset, len(upload.Pending), tt.length)
+			}
+
+			if len(api.parts) != telegram.PartCount(tt.length) {
+				t.Fatalf("got %d parts, want %d", len(api.parts), telegram.PartCount(tt.length))
+			}
+			var joined []byte
+			for i := 0; i < len(api.parts); i++ {
+				p, ok := api.parts[i]
+				if !ok {
+					t.Fatalf("part %d missing", i)
+				}
+				if i < len(api.parts)-1 && len(p) != part {
+					t.Fatalf("part %d is %d bytes, want %d", i, len(p), part)
+				}
+				joined = append(joined, p...)
+			}
+			if !bytes.Equal(joined, data) {
+				t.Fatal("parts don't add up to the file")
+			}
+		})
+	}
+}
+
+func TestReceiveTusDataShortBody(t *testing.T) {
+	st, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	api := &partRecorder{parts: make(map[int][]byte)}
+	s := &Server{storage: st, uploader: telegram.NewUploader(nil, tg.NewClient(api), nil, 1)}
+	upload := &storage.TusUpload{ID: "upload", UserID: 1, FileName: "f", MimeType: "m", Length: 2 * telegram.UploadPartSize}
+	if err := st.CreateTusUpload(upload); err != nil {
+		t.Fatal(err)
+	}
+
+	// A connection dropping mid-part keeps what arrived
+	body := io.MultiReader(bytes.NewReader(make([]byte, 300)), errReader{io.ErrClosedPipe})
+	if err := s.receiveTusData(context.Background(), upload, body); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("got %v, want the read error", err)
+	}
+	saved, err := st.GetTusUpload(upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Offset != 300 || len(saved.Pending) != 300 || len(api.parts) != 0 {
+		t.Fatalf("offset %d with %d pending bytes and %d parts, want 300, 300 and 0", saved.Offset, len(saved.Pending), len(api.parts))
+	}
+}
+
+// errReader fails every read
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"tele-bot/storage"
+)
+
+// countingWriter counts the bytes written to a response and remembers its status
+type countingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	bytesStreamed.Add(float64(n))
+	return n, err
+}
+
+// sentContent reports whether the response carried any of the file, which HEAD, 304 Not Modified,
+// 416 Range Not Satisfiable and error responses don't
+func (w *countingWriter) sentContent(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return false
+	}
+	switch w.status {
+	case http.StatusOK, http.StatusPartialContent:
+		return true
+	case 0:
+		return w.written > 0
+	}
+	return false
+}
+
+// completed reports whether the response delivered everything it promised up to the file's last
+// byte, which is when a download counts towards a link's stats. Resumed downloads count when
+// they finish, the requests of a player seeking around mostly don't.
+func (w *countingWriter) completed(size int64) bool {
+	switch w.status {
+	case 0, http.StatusOK:
+		return w.written == size
+	case http.StatusPartialContent:
+		// A single range is sent as "bytes first-last/size", several as multipart without the header
+		var first, last, total int64
+		_, err := fmt.Sscanf(w.Header().Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total)
+		return err == nil && last == size-1 && w.written == last-first+1
+	}
+	return false
+}
+
+// Downloads returns the downloads in progress and the bytes served since the server started
+func (s *Server) Downloads() (active, served int64) {
+	return s.activeDownloads.Load(), s.bytesServed.Load()
+}
+
+// finishDownload adds a finished download to the service totals, the audit log, the link's
+// stats and the file owner's monthly egress. Responses without file content aren't downloads.
+func (s *Server) finishDownload(r *http.Request, meta *storage.FileMetadata, counter *countingWriter) {
+	s.activeDownloads.Add(-1)
+	downloadsInProgress.Dec()
+	s.bytesServed.Add(counter.written)
+
+	if !counter.sentContent(r) {
+		return
+	}
+	entry := &storage.AuditEntry{
+		Action:    storage.AuditDownload,
+		Target:    meta.LinkID,
+		ClientIP:  s.clientIP(r),
+		UserAgent: r.UserAgent(),
+		ByteRange: r.Header.Get("Range"),
+		Bytes:     counter.written,
+	}
+	if err := s.storage.RecordDownload(entry, meta.OwnerID, counter.completed(meta.FileSize)); err != nil {
+		log.Printf("⚠️ Failed to record download of %s: %v", meta.LinkID, err)
+	}
+}
+
+// overEgressQuota reports whether a file owner has used up their monthly egress
+func (s *Server) overEgressQuota(ownerID int64) bool {
+	if ownerID == 0 || s.quotas.MonthlyEgress == 0 {
+		return false
+	}
+	usage, err := s.storage.GetUsage(ownerID)
+	if err != nil {
+		log.Printf("⚠️ Failed to check egress quota of user %d: %v", ownerID, err)
+		return false
+	}
+	return s.quotas.EgressExceeded(usage)
+}
+
+// checkLinkQuota writes a 403 and returns false when a new link to a file of size bytes would take
+// the user over a quota
+func (s *Server) checkLinkQuota(w http.ResponseWriter, userID int64, size int64) bool {
+	if s.quotas.MaxLinks == 0 && s.quotas.MaxLinkedBytes == 0 {
+		return true
+	}
+	usage, err := s.storage.GetUsage(userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to check quota of user %d: %v", userID, err)
+		return true
+	}
+
+	var quotaErr *storage.QuotaError
+	if err := s.quotas.CheckNewLink(usage, size); errors.As(err, &quotaErr) {
+		writeAPIError(w, http.StatusForbidden, "quota_exceeded", "Quota exceeded: "+quotaErr.Reason)
+		return false
+	}
+	return true
+}
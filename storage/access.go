@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Access rules stored in AccessRule.Rule
+const (
+	AccessAllow = "allow"
+	AccessDeny  = "deny"
+)
+
+// AccessDecision is the outcome of checking a user against the access rules
+type AccessDecision int
+
+const (
+	AccessAllowed AccessDecision = iota
+	AccessDenied
+	AccessUnknown // Neither allowed nor denied when access needs approval
+)
+
+// AccessPolicy is the access configuration that stored rules are applied on top of
+type AccessPolicy struct {
+	Approval bool              // Only allowed subjects may use the bot
+	Admins   map[int64]bool    // Always allowed
+	Rules    map[string]string // Rules from the config by subject; stored rules take precedence
+}
+
+// AccessRule allows or denies a user or chat ID (as a decimal string) or an @username
+type AccessRule struct {
+	Subject   string
+	Rule      string
+	AddedBy   int64 // Admin who added the rule
+	CreatedAt time.Time
+}
+
+// AccessRequest is an unknown user waiting for an admin to let them use the bot
+type AccessRequest struct {
+	UserID    int64
+	Username  string
+	Name      string
+	CreatedAt time.Time
+}
+
+// CheckAccess applies the access rules to a user and the other subjects they act as, like their
+// @username or the chat they write in. A deny rule for any of them wins over allow rules for the others.
+func (s *Storage) CheckAccess(policy AccessPolicy, userID int64, subjects ...string) AccessDecision {
+	if policy.Admins[userID] {
+		return AccessAllowed
+	}
+
+	allowed := false
+	for _, subject := range append([]string{strconv.FormatInt(userID, 10)}, subjects...) {
+		switch s.accessRule(policy, subject) {
+		case AccessDeny:
+			return AccessDenied
+		case AccessAllow:
+			allowed = true
+		}
+	}
+	if allowed || !policy.Approval {
+		return AccessAllowed
+	}
+	return AccessUnknown
+}
+
+// accessRule returns the rule for a subject, stored rules first
+func (s *Storage) accessRule(policy AccessPolicy, subject string) string {
+	rule, err := s.GetAccessRule(subject)
+	if err != nil {
+		log.Printf("⚠️ Failed to load access rule: %v", err)
+	}
+	if rule != "" {
+		return rule
+	}
+	return policy.Rules[subject]
+}
+
+// SetAccessRule allows or denies a subject, replacing any earlier rule for it
+func (s *Storage) SetAccessRule(subject, rule string, addedBy int64) error {
+	query := `INSERT INTO access_rules (subject, rule, added_by) VALUES (?, ?, ?)
+		ON CONFLICT(subject) DO UPDATE SET rule = excluded.rule, added_by = excluded.added_by, created_at = CURRENT_TIMESTAMP`
+	_, err := s.db.Exec(query, subject, rule, addedBy)
+	return err
+}
+
+// GetAccessRule returns the rule for a subject, or "" if there is none
+func (s *Storage) GetAccessRule(subject string) (string, error) {
+	var rule string
+	err := s.db.QueryRow(`SELECT rule FROM access_rules WHERE subject = ?`, subject).Scan(&rule)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return rule, err
+}
+
+// ListAccessRules returns all stored rules, newest first
+func (s *Storage) ListAccessRules() ([]*AccessRule, error) {
+	rows, err := s.db.Query(`SELECT subject, rule, added_by, created_at FROM access_rules ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*AccessRule
+	for rows.Next() {
+		var r AccessRule
+		if err := rows.Scan(&r.Subject, &r.Rule, &r.AddedBy, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &r)
+	}
+	return rules, rows.Err()
+}
+
+// CreateAccessRequest records an access request. It reports false if the user already has one pending.
+func (s *Storage) CreateAccessRequest(req *AccessRequest) (bool, error) {
+	res, err := s.db.Exec(`INSERT OR IGNORE INTO access_requests (user_id, username, name) VALUES (?, ?, ?)`,
+		req.UserID, req.Username, req.Name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetAccessRequest returns a user's pending request, or nil if there is none
+func (s *Storage) GetAccessRequest(userID int64) (*AccessRequest, error) {
+	var r AccessRequest
+	err := s.db.QueryRow(`SELECT user_id, username, name, created_at FROM access_requests WHERE user_id = ?`, userID).
+		Scan(&r.UserID, &r.Username, &r.Name, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListAccessRequests returns pending requests, oldest first
+func (s *Storage) ListAccessRequests(limit int) ([]*AccessRequest, error) {
+	rows, err := s.db.Query(`SELECT user_id, username, name, created_at FROM access_requests ORDER BY created_at, user_id LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*AccessRequest
+	for rows.Next() {
+		var r AccessRequest
+		if err := rows.Scan(&r.UserID, &r.Username, &r.Name, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, &r)
+	}
+	return requests, rows.Err()
+}
+
+// DeleteAccessRequest removes a user's pending request once it's been decided
+func (s *Storage) DeleteAccessRequest(userID int64) error {
+	_, err := s.db.Exec(`DELETE FROM access_requests WHERE user_id = ?`, userID)
+	return err
+}
+
+// DeleteAccessRule removes the rule for a subject, reporting false if there was none
+func (s *Storage) DeleteAccessRule(subject string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM access_rules WHERE subject = ?`, subject)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditLinkCreate = "link_create"
+	AuditDownload   = "download"
+	AuditRevoke     = "revoke"
+
+	// Admin actions
+	AuditAllow     = "allow"
+	AuditDeny      = "deny"
+	AuditBan       = "ban"
+	AuditUnban     = "unban"
+	AuditBroadcast = "broadcast"
+	AuditLookup    = "lookup"
+	AuditPurge     = "purge"
+	AuditStats     = "stats"
+	AuditThrottle  = "throttle" // Download limits changed through the admin API
+)
+
+// auditTimeFormat is how SQLite's CURRENT_TIMESTAMP writes created_at, which filters compare against.
+// Rows that set created_at themselves use it too.
+const auditTimeFormat = "2006-01-02 15:04:05"
+
+// retentionInterval is how often audit entries and visitors past their retention period are deleted
+const retentionInterval = time.Hour
+
+// AuditEntry is one action recorded in the audit log
+type AuditEntry struct {
+	ID        int64
+	CreatedAt time.Time
+	ActorID   int64  // User who took the action, 0 for anonymous downloads
+	Action    string // One of the Audit* constants
+	Target    string // What it was taken on, like a user ID or link ID
+	Detail    string
+
+	// Downloads only, apart from ClientIP of admin API actions
+	ClientIP  string
+	UserAgent string
+	ByteRange string // Range header, empty for the whole file
+	Bytes     int64  // Bytes actually sent
+}
+
+// AuditFilter selects audit entries; zero fields match everything
+type AuditFilter struct {
+	Action   string
+	ActorID  int64
+	Target   string
+	Since    time.Time
+	Until    time.Time
+	BeforeID int64 // Only entries older than this one, for paging
+	Limit    int   // 0 for all
+}
+
+// execer is a database or transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// AddAudit appends an entry to the audit log
+func (s *Storage) AddAudit(entry *AuditEntry) error {
+	return addAudit(s.db, entry)
+}
+
+func addAudit(db execer, entry *AuditEntry) error {
+	_, err := db.Exec(`INSERT INTO audit_log (actor_id, action, target, detail, client_ip, user_agent, byte_range, bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ActorID, entry.Action, entry.Target, entry.Detail,
+		entry.ClientIP, entry.UserAgent, entry.ByteRange, entry.Bytes)
+	return err
+}
+
+// linkOrigin describes the message a link's file was taken from, for the audit log
+func linkOrigin(meta *FileMetadata) string {
+	switch {
+	case meta.SourceChannelID != 0:
+		return fmt.Sprintf("channel %d message %d", meta.SourceChannelID, meta.SourceMessageID)
+	case meta.SourceMessageID != 0:
+		return fmt.Sprintf("private chat message %d", meta.SourceMessageID)
+	}
+	return ""
+}
+
+// EachAudit calls fn for every entry matching filter, newest first
+func (s *Storage) EachAudit(filter AuditFilter, fn func(*AuditEntry) error) error {
+	var where []string
+	var args []any
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.ActorID != 0 {
+		where = append(where, "actor_id = ?")
+		args = append(args, filter.ActorID)
+	}
+	if filter.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.Since.UTC().Format(auditTimeFormat))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.Until.UTC().Format(auditTimeFormat))
+	}
+	if filter.BeforeID != 0 {
+		where = append(where, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+
+	query := `SELECT id, created_at, actor_id, action, target, detail, client_ip, user_agent, byte_range, bytes FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.ActorID, &e.Action, &e.Target, &e.Detail,
+			&e.ClientIP, &e.UserAgent, &e.ByteRange, &e.Bytes)
+		if err != nil {
+			return err
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListAudit returns the entries matching filter, newest first
+func (s *Storage) ListAudit(filter AuditFilter) ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+	err := s.EachAudit(filter, func(e *AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// PruneAudit deletes entries older than before and returns how many there were.
+// It's the only way entries leave the log, which refuses updates.
+func (s *Storage) PruneAudit(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM audit_log WHERE created_at < ?`, before.UTC().Format(auditTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunRetention deletes audit entries older than auditMaxAge, unless it's 0, and visitors past the
+// stats window every hour until ctx is done
+func (s *Storage) RunRetention(ctx context.Context, auditMaxAge time.Duration) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		if auditMaxAge > 0 {
+			n, err := s.PruneAudit(time.Now().Add(-auditMaxAge))
+			if err != nil {
+				log.Printf("⚠️ Failed to prune audit log: %v", err)
+			} else if n > 0 {
+				log.Printf("🧹 Pruned %d audit log entries", n)
+			}
+		}
+
+		n, err := s.PruneVisitors()
+		if err != nil {
+			log.Printf("⚠️ Failed to prune link visitors: %v", err)
+		} else if n > 0 {
+			log.Printf("🧹 Forgot %d link visitor(s)", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
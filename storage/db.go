@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "telebot_sqlite_query_duration_seconds",
+	Help:    "SQLite statement latency by kind of statement; for queries, until the first row is ready.",
+	Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1},
+}, []string{"op"})
+
+// timedDB is a database whose statements are timed for the metrics
+type timedDB struct {
+	*sql.DB
+}
+
+func (db timedDB) Exec(query string, args ...any) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return db.DB.Exec(query, args...)
+}
+
+func (db timedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return db.DB.Query(query, args...)
+}
+
+func (db timedDB) QueryRow(query string, args ...any) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return db.DB.QueryRow(query, args...)
+}
+
+func (db timedDB) Begin() (timedTx, error) {
+	tx, err := db.DB.Begin()
+	return timedTx{tx}, err
+}
+
+// timedTx is a transaction whose statements and commit are timed for the metrics
+type timedTx struct {
+	*sql.Tx
+}
+
+func (tx timedTx) Exec(query string, args ...any) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return tx.Tx.Exec(query, args...)
+}
+
+func (tx timedTx) Query(query string, args ...any) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return tx.Tx.Query(query, args...)
+}
+
+func (tx timedTx) Commit() error {
+	defer observeQuery("commit", time.Now())
+	return tx.Tx.Commit()
+}
+
+// observeQuery records a statement's latency under its first keyword, like "select"
+func observeQuery(query string, start time.Time) {
+	op := strings.TrimSpace(query)
+	if i := strings.IndexFunc(op, unicode.IsSpace); i >= 0 {
+		op = op[:i]
+	}
+	queryDuration.WithLabelValues(strings.ToLower(op)).Observe(time.Since(start).Seconds())
+}
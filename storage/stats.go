@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// statsHourFormat is the hour a link_stats row rolls up, in UTC
+	statsHourFormat = "2006-01-02 15"
+
+	// StatsDays is how many days link stats chart, and how long visitors are remembered for them
+	StatsDays = 7
+)
+
+// LinkStats sums up the downloads of one link
+type LinkStats struct {
+	Downloads int64 // Completed downloads
+	Bytes     int64 // Bytes served, including partial and ranged downloads
+	UniqueIPs int64
+	Days      []DayStats // Oldest first, one per day including days without downloads
+}
+
+// DayStats are one UTC day of a link's downloads
+type DayStats struct {
+	Day       time.Time
+	Downloads int64
+	Bytes     int64
+}
+
+// visitorKey hashes a client IP with the install's secret, so the stats can count visitors without
+// keeping their addresses in a form that can be reversed by hashing every IP
+func (s *Storage) visitorKey(linkID, ip string) string {
+	mac := hmac.New(sha256.New, s.visitorSecret)
+	mac.Write([]byte(linkID + "|" + ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// loadVisitorSecret reads the secret visitor keys are hashed with, creating it on first start
+func (s *Storage) loadVisitorSecret() error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO secrets (name, value) VALUES ('visitor', ?)`, secret)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(`SELECT value FROM secrets WHERE name = 'visitor'`).Scan(&s.visitorSecret)
+}
+
+// RecordDownload audits a download of the link entry.Target, adds the bytes it sent to entry.ClientIP
+// to the link's hourly rollup and to the file owner's monthly egress, all or nothing.
+// The download counts towards the link's downloads if it completed.
+func (s *Storage) RecordDownload(entry *AuditEntry, ownerID int64, completed bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addAudit(tx, entry); err != nil {
+		return err
+	}
+
+	var downloads int64
+	if completed {
+		downloads = 1
+	}
+	hour := time.Now().UTC().Format(statsHourFormat)
+	_, err = tx.Exec(`INSERT INTO link_stats (link_id, hour, downloads, bytes) VALUES (?, ?, ?, ?)
+		ON CONFLICT(link_id, hour) DO UPDATE SET downloads = downloads + excluded.downloads, bytes = bytes + excluded.bytes`,
+		entry.Target, hour, downloads, entry.Bytes)
+	if err != nil {
+		return err
+	}
+
+	if entry.ClientIP != "" {
+		_, err = tx.Exec(`INSERT INTO link_visitors (link_id, visitor, hour) VALUES (?, ?, ?)
+			ON CONFLICT(link_id, visitor) DO UPDATE SET hour = excluded.hour`,
+			entry.Target, s.visitorKey(entry.Target, entry.ClientIP), hour)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ownerID != 0 && entry.Bytes > 0 {
+		if err := addEgress(tx, ownerID, entry.Bytes); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetLinkStats returns a link's all-time totals, and its unique visitors and downloads on each of
+// the last days UTC days
+func (s *Storage) GetLinkStats(linkID string, days int) (*LinkStats, error) {
+	var stats LinkStats
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(downloads), 0), COALESCE(SUM(bytes), 0) FROM link_stats WHERE link_id = ?`, linkID).
+		Scan(&stats.Downloads, &stats.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, 1-days)
+
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM link_visitors WHERE link_id = ? AND hour >= ?`,
+		linkID, first.Format(statsHourFormat)).Scan(&stats.UniqueIPs)
+	if err != nil {
+		return nil, err
+	}
+	stats.Days = make([]DayStats, days)
+	for i := range stats.Days {
+		stats.Days[i].Day = first.AddDate(0, 0, i)
+	}
+
+	rows, err := s.db.Query(`SELECT substr(hour, 1, 10), SUM(downloads), SUM(bytes) FROM link_stats
+		WHERE link_id = ? AND hour >= ? GROUP BY 1`, linkID, first.Format(statsHourFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day string
+		var downloads, bytes int64
+		if err := rows.Scan(&day, &downloads, &bytes); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			continue
+		}
+		if i := int(t.Sub(first) / (24 * time.Hour)); i >= 0 && i < days {
+			stats.Days[i].Downloads = downloads
+			stats.Days[i].Bytes = bytes
+		}
+	}
+	return &stats, rows.Err()
+}
+
+// PruneVisitors forgets visitors last seen before the StatsDays window and returns how many there were
+func (s *Storage) PruneVisitors() (int64, error) {
+	first := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-StatsDays)
+	res, err := s.db.Exec(`DELETE FROM link_visitors WHERE hour < ?`, first.Format(statsHourFormat))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// deleteLinkStats removes the stats of the links selected by a query with one argument
+func deleteLinkStats(db execer, links string, arg any) error {
+	if _, err := db.Exec(`DELETE FROM link_stats WHERE link_id IN (`+links+`)`, arg); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM link_visitors WHERE link_id IN (`+links+`)`, arg)
+	return err
+}
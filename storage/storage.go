@@ -20,12 +20,87 @@ type FileMetadata struct {
 	FileName      string
 	FileSize      int64
 	MimeType      string
+	MimeSniffed   bool // MimeType was detected from the content, or detection found nothing better
 	CreatedAt     time.Time
+
+	// Thumbnail info from the document's Thumbs
+	ThumbType     string // PhotoSize type to request with ThumbSize, empty if none
+	ThumbSize     int64
+	ThumbStripped []byte // PhotoStrippedSize payload, expanded to JPEG on demand
+
+	// Media attributes from DocumentAttributeVideo / DocumentAttributeAudio
+	MediaKind         string  // One of the Media* constants, empty for plain documents
+	Width             int     // Video width in pixels
+	Height            int     // Video height in pixels
+	Duration          float64 // Seconds
+	SupportsStreaming bool
+	AudioTitle        string
+	AudioPerformer    string
+
+	OwnerID    int64  // Telegram user who uploaded the file, 0 if unknown
+	Collection string // Playlist the file belongs to, empty if none
+
+	ExpiresAt    *time.Time // Link stops working after this time, nil for never
+	PasswordHash string     // bcrypt hash of the download password, empty if none
+
+	// Message the file can be re-fetched from when its file reference expires.
+	// SourceChannelID is 0 for messages in the bot's private chats.
+	SourceChannelID  int64
+	SourceAccessHash int64
+	SourceMessageID  int
+
+	SHA256 []byte // Digest of the whole file, nil until a verified full download computed it
+}
+
+// Expired reports whether the link's expiry time has passed
+func (m *FileMetadata) Expired() bool {
+	return m.ExpiresAt != nil && time.Now().After(*m.ExpiresAt)
+}
+
+// Media kinds stored in FileMetadata.MediaKind
+const (
+	MediaVideo     = "video"
+	MediaRound     = "round"
+	MediaAnimation = "animation"
+	MediaAudio     = "audio"
+	MediaVoice     = "voice"
+	MediaSticker   = "sticker"
+)
+
+// fileColumns lists the files columns in the order scanFile expects
+const fileColumns = `id, link_id, file_id, access_hash, file_reference, file_name, file_size, mime_type, created_at,
+	thumb_type, thumb_size, thumb_stripped,
+	media_kind, width, height, duration, supports_streaming, audio_title, audio_performer,
+	owner_id, collection, expires_at, password_hash,
+	source_channel_id, source_access_hash, source_message_id, sha256, mime_sniffed`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanFile reads a row selected with fileColumns
+func scanFile(row rowScanner) (*FileMetadata, error) {
+	var meta FileMetadata
+	var expiresAt sql.NullTime
+	err := row.Scan(&meta.ID, &meta.LinkID, &meta.FileID, &meta.AccessHash, &meta.FileReference, &meta.FileName, &meta.FileSize, &meta.MimeType, &meta.CreatedAt,
+		&meta.ThumbType, &meta.ThumbSize, &meta.ThumbStripped,
+		&meta.MediaKind, &meta.Width, &meta.Height, &meta.Duration, &meta.SupportsStreaming, &meta.AudioTitle, &meta.AudioPerformer,
+		&meta.OwnerID, &meta.Collection, &expiresAt, &meta.PasswordHash,
+		&meta.SourceChannelID, &meta.SourceAccessHash, &meta.SourceMessageID, &meta.SHA256, &meta.MimeSniffed)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		meta.ExpiresAt = &expiresAt.Time
+	}
+	return &meta, nil
 }
 
 // Storage handles database operations
 type Storage struct {
-	db *sql.DB
+	db            timedDB
+	visitorSecret []byte // Key of the hashes link_visitors keeps instead of client IPs
 }
 
 // New creates a new Storage instance
@@ -36,16 +111,23 @@ func New(dbPath string) (*Storage, error) {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	// WAL lets downloads be recorded while the audit log is exported. Writers wait for each other
+	// instead of failing with "database is locked", and transactions take the write lock up front
+	// so they never have to upgrade a read lock.
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	s := &Storage{db: db}
+	s := &Storage{db: timedDB{db}}
 	if err := s.initSchema(); err != nil {
 		db.Close()
 		return nil, err
 	}
+	if err := s.loadVisitorSecret(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load visitor secret: %w", err)
+	}
 
 	return s, nil
 }
@@ -62,9 +144,102 @@ func (s *Storage) initSchema() error {
 		file_name TEXT NOT NULL,
 		file_size INTEGER NOT NULL,
 		mime_type TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		thumb_type TEXT NOT NULL DEFAULT '',
+		thumb_size INTEGER NOT NULL DEFAULT 0,
+		thumb_stripped BLOB,
+		media_kind TEXT NOT NULL DEFAULT '',
+		width INTEGER NOT NULL DEFAULT 0,
+		height INTEGER NOT NULL DEFAULT 0,
+		duration REAL NOT NULL DEFAULT 0,
+		supports_streaming BOOLEAN NOT NULL DEFAULT 0,
+		audio_title TEXT NOT NULL DEFAULT '',
+		audio_performer TEXT NOT NULL DEFAULT '',
+		owner_id INTEGER NOT NULL DEFAULT 0,
+		collection TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME,
+		password_hash TEXT NOT NULL DEFAULT '',
+		source_channel_id INTEGER NOT NULL DEFAULT 0,
+		source_access_hash INTEGER NOT NULL DEFAULT 0,
+		source_message_id INTEGER NOT NULL DEFAULT 0
 	);
 	CREATE INDEX IF NOT EXISTS idx_link_id ON files(link_id);
+
+	CREATE TABLE IF NOT EXISTS user_settings (
+		user_id INTEGER PRIMARY KEY,
+		active_collection TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS tus_uploads (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		upload_id INTEGER NOT NULL,
+		file_name TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		length INTEGER NOT NULL,
+		upload_offset INTEGER NOT NULL DEFAULT 0,
+		pending BLOB,
+		link_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS user_usage (
+		user_id INTEGER NOT NULL,
+		month TEXT NOT NULL,
+		egress_bytes INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, month)
+	);
+
+	CREATE TABLE IF NOT EXISTS access_rules (
+		subject TEXT PRIMARY KEY,
+		rule TEXT NOT NULL,
+		added_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS access_requests (
+		user_id INTEGER PRIMARY KEY,
+		username TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		actor_id INTEGER NOT NULL DEFAULT 0,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		detail TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS link_stats (
+		link_id TEXT NOT NULL,
+		hour TEXT NOT NULL,
+		downloads INTEGER NOT NULL DEFAULT 0,
+		bytes INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (link_id, hour)
+	);
+
+	CREATE TABLE IF NOT EXISTS link_visitors (
+		link_id TEXT NOT NULL,
+		visitor TEXT NOT NULL,
+		hour TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (link_id, visitor)
+	);
+
+	CREATE TABLE IF NOT EXISTS secrets (
+		name TEXT PRIMARY KEY,
+		value BLOB NOT NULL
+	);
 	`
 	if _, err := s.db.Exec(query); err != nil {
 		return err
@@ -74,32 +249,200 @@ func (s *Storage) initSchema() error {
 	// In a real app, check schema version.
 	s.db.Exec("ALTER TABLE files ADD COLUMN access_hash INTEGER DEFAULT 0")
 	s.db.Exec("ALTER TABLE files ADD COLUMN file_reference BLOB")
+	s.db.Exec("ALTER TABLE files ADD COLUMN thumb_type TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("ALTER TABLE files ADD COLUMN thumb_size INTEGER NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN thumb_stripped BLOB")
+	s.db.Exec("ALTER TABLE files ADD COLUMN media_kind TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("ALTER TABLE files ADD COLUMN width INTEGER NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN height INTEGER NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN duration REAL NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN supports_streaming BOOLEAN NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN audio_title TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("ALTER TABLE files ADD COLUMN audio_performer TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("ALTER TABLE files ADD COLUMN owner_id INTEGER NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN collection TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_collection ON files(collection)")
+	s.db.Exec("ALTER TABLE files ADD COLUMN expires_at DATETIME")
+	s.db.Exec("ALTER TABLE files ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_owner_id ON files(owner_id)")
+	s.db.Exec("ALTER TABLE files ADD COLUMN source_channel_id INTEGER NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN source_access_hash INTEGER NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN source_message_id INTEGER NOT NULL DEFAULT 0")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_file_id ON files(file_id)")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_source ON files(source_channel_id, source_message_id)")
+	s.db.Exec("ALTER TABLE files ADD COLUMN sha256 BLOB")
+	s.db.Exec("ALTER TABLE audit_log ADD COLUMN client_ip TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("ALTER TABLE audit_log ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("ALTER TABLE audit_log ADD COLUMN byte_range TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("ALTER TABLE audit_log ADD COLUMN bytes INTEGER NOT NULL DEFAULT 0")
+	s.db.Exec("ALTER TABLE files ADD COLUMN mime_sniffed BOOLEAN NOT NULL DEFAULT 0")
+	// Visitors hashed before the secret existed have no hour and go with the next prune
+	s.db.Exec("ALTER TABLE link_visitors ADD COLUMN hour TEXT NOT NULL DEFAULT ''")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_created_at ON audit_log(created_at)")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_target ON audit_log(target)")
+	// The audit log is append-only, entries only leave it through retention
+	s.db.Exec(`CREATE TRIGGER IF NOT EXISTS audit_log_append_only BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`)
 
 	return nil
 }
 
-// SaveFile stores file metadata and returns the assigned link ID
-func (s *Storage) SaveFile(linkID string, fileID int64, accessHash int64, fileReference []byte, fileName string, fileSize int64, mimeType string) error {
-	query := `INSERT INTO files (link_id, file_id, access_hash, file_reference, file_name, file_size, mime_type) VALUES (?, ?, ?, ?, ?, ?, ?)`
-	_, err := s.db.Exec(query, linkID, fileID, accessHash, fileReference, fileName, fileSize, mimeType)
-	return err
+// scanFiles reads all rows selected with fileColumns
+func scanFiles(rows *sql.Rows) ([]*FileMetadata, error) {
+	var files []*FileMetadata
+	for rows.Next() {
+		meta, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, meta)
+	}
+	return files, rows.Err()
+}
+
+// SaveFile stores file metadata under meta.LinkID and audits the link's creation
+func (s *Storage) SaveFile(meta *FileMetadata) error {
+	query := `INSERT INTO files (link_id, file_id, access_hash, file_reference, file_name, file_size, mime_type,
+		thumb_type, thumb_size, thumb_stripped,
+		media_kind, width, height, duration, supports_streaming, audio_title, audio_performer,
+		owner_id, collection, expires_at, password_hash,
+		source_channel_id, source_access_hash, source_message_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(query, meta.LinkID, meta.FileID, meta.AccessHash, meta.FileReference, meta.FileName, meta.FileSize, meta.MimeType,
+		meta.ThumbType, meta.ThumbSize, meta.ThumbStripped,
+		meta.MediaKind, meta.Width, meta.Height, meta.Duration, meta.SupportsStreaming, meta.AudioTitle, meta.AudioPerformer,
+		meta.OwnerID, meta.Collection, meta.ExpiresAt, meta.PasswordHash,
+		meta.SourceChannelID, meta.SourceAccessHash, meta.SourceMessageID)
+	if err != nil {
+		return err
+	}
+
+	// Every link is audited with its uploader and origin, or not created at all
+	entry := &AuditEntry{ActorID: meta.OwnerID, Action: AuditLinkCreate, Target: meta.LinkID, Detail: linkOrigin(meta)}
+	if err := addAudit(tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetFileByLink retrieves file metadata by link ID
 func (s *Storage) GetFileByLink(linkID string) (*FileMetadata, error) {
-	query := `SELECT id, link_id, file_id, access_hash, file_reference, file_name, file_size, mime_type, created_at FROM files WHERE link_id = ?`
-	row := s.db.QueryRow(query, linkID)
+	query := `SELECT ` + fileColumns + ` FROM files WHERE link_id = ?`
+	meta, err := scanFile(s.db.QueryRow(query, linkID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	var meta FileMetadata
-	err := row.Scan(&meta.ID, &meta.LinkID, &meta.FileID, &meta.AccessHash, &meta.FileReference, &meta.FileName, &meta.FileSize, &meta.MimeType, &meta.CreatedAt)
+	return meta, nil
+}
+
+// GetFileBySource finds the link created for a channel message, or returns nil if there is none
+func (s *Storage) GetFileBySource(channelID int64, messageID int) (*FileMetadata, error) {
+	query := `SELECT ` + fileColumns + ` FROM files WHERE source_channel_id = ? AND source_message_id = ? LIMIT 1`
+	meta, err := scanFile(s.db.QueryRow(query, channelID, messageID))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	return meta, nil
+}
 
-	return &meta, nil
+// ListFilesByOwner returns up to limit of a user's files, newest first, with IDs below beforeID (0 for the first page)
+func (s *Storage) ListFilesByOwner(ownerID int64, beforeID int64, limit int) ([]*FileMetadata, error) {
+	query := `SELECT ` + fileColumns + ` FROM files WHERE owner_id = ? AND (? = 0 OR id < ?) ORDER BY id DESC LIMIT ?`
+	rows, err := s.db.Query(query, ownerID, beforeID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanFiles(rows)
+}
+
+// UpdateFile saves the user-editable fields of a file: name, expiry and password
+func (s *Storage) UpdateFile(meta *FileMetadata) error {
+	query := `UPDATE files SET file_name = ?, expires_at = ?, password_hash = ? WHERE link_id = ?`
+	_, err := s.db.Exec(query, meta.FileName, meta.ExpiresAt, meta.PasswordHash, meta.LinkID)
+	return err
+}
+
+// DeleteFile revokes a file's link and removes its download stats on behalf of actorID.
+// The revoke is audited with detail, or the link isn't deleted at all.
+func (s *Storage) DeleteFile(linkID string, actorID int64, detail string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM files WHERE link_id = ?`, linkID); err != nil {
+		return err
+	}
+	if err := deleteLinkStats(tx, `?`, linkID); err != nil {
+		return err
+	}
+	entry := &AuditEntry{ActorID: actorID, Action: AuditRevoke, Target: linkID, Detail: detail}
+	if err := addAudit(tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListCollection returns the files in a collection in upload order
+func (s *Storage) ListCollection(collection string) ([]*FileMetadata, error) {
+	query := `SELECT ` + fileColumns + ` FROM files WHERE collection = ? ORDER BY created_at, id`
+	rows, err := s.db.Query(query, collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanFiles(rows)
+}
+
+// SetActiveCollection sets the collection a user's new uploads are added to ("" clears it)
+func (s *Storage) SetActiveCollection(userID int64, collection string) error {
+	query := `INSERT INTO user_settings (user_id, active_collection) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET active_collection = excluded.active_collection`
+	_, err := s.db.Exec(query, userID, collection)
+	return err
+}
+
+// GetActiveCollection returns the collection a user's new uploads are added to, or ""
+func (s *Storage) GetActiveCollection(userID int64) (string, error) {
+	var collection string
+	err := s.db.QueryRow(`SELECT active_collection FROM user_settings WHERE user_id = ?`, userID).Scan(&collection)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return collection, err
+}
+
+// UpdateFileReference stores a refreshed file reference for every link to a Telegram file
+func (s *Storage) UpdateFileReference(fileID int64, fileReference []byte) error {
+	_, err := s.db.Exec(`UPDATE files SET file_reference = ? WHERE file_id = ?`, fileReference, fileID)
+	return err
+}
+
+// UpdateFileDigest stores the SHA-256 of a Telegram file for every link to it
+func (s *Storage) UpdateFileDigest(fileID int64, sum []byte) error {
+	_, err := s.db.Exec(`UPDATE files SET sha256 = ? WHERE file_id = ?`, sum, fileID)
+	return err
+}
+
+// UpdateMimeType replaces the stored MIME type of a file with the sniffed one, so it isn't sniffed again
+func (s *Storage) UpdateMimeType(linkID string, mimeType string) error {
+	_, err := s.db.Exec(`UPDATE files SET mime_type = ?, mime_sniffed = 1 WHERE link_id = ?`, mimeType, linkID)
+	return err
 }
 
 // Close closes the database connection
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// TusUploadTTL is how long an upload can be resumed after it was created. Telegram discards
+// unused parts after a while, so a later resume would fail when the file is published.
+const TusUploadTTL = 24 * time.Hour
+
+// tusSweepInterval is how often expired uploads are deleted
+const tusSweepInterval = time.Hour
+
+// TusUpload is the state of a resumable upload.
+// Completed parts live on Telegram already; only the part in progress is kept here.
+type TusUpload struct {
+	ID        string // Upload ID used in the tus URL
+	UserID    int64
+	UploadID  int64 // Telegram file ID the parts are saved under
+	FileName  string
+	MimeType  string
+	Length    int64  // Total size declared with Upload-Length
+	Offset    int64  // Bytes received so far, including Pending
+	Pending   []byte // Received bytes of the part that isn't complete yet
+	LinkID    string // Set once the upload is complete and linked
+	CreatedAt time.Time
+}
+
+// ExpiresAt returns when the upload can no longer be resumed
+func (u *TusUpload) ExpiresAt() time.Time {
+	return u.CreatedAt.Add(TusUploadTTL)
+}
+
+// Expired reports whether an incomplete upload can no longer be resumed
+func (u *TusUpload) Expired() bool {
+	return u.LinkID == "" && time.Now().After(u.ExpiresAt())
+}
+
+// CreateTusUpload stores a new resumable upload and sets its CreatedAt
+func (s *Storage) CreateTusUpload(u *TusUpload) error {
+	u.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO tus_uploads (id, user_id, upload_id, file_name, mime_type, length, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.Exec(query, u.ID, u.UserID, u.UploadID, u.FileName, u.MimeType, u.Length, u.CreatedAt.Format(auditTimeFormat))
+	return err
+}
+
+// GetTusUpload loads a resumable upload, or returns nil if it doesn't exist
+func (s *Storage) GetTusUpload(id string) (*TusUpload, error) {
+	query := `SELECT id, user_id, upload_id, file_name, mime_type, length, upload_offset, pending, link_id, created_at FROM tus_uploads WHERE id = ?`
+
+	var u TusUpload
+	err := s.db.QueryRow(query, id).Scan(&u.ID, &u.UserID, &u.UploadID, &u.FileName, &u.MimeType, &u.Length, &u.Offset, &u.Pending, &u.LinkID, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UpdateTusUpload saves the progress of a resumable upload
+func (s *Storage) UpdateTusUpload(u *TusUpload) error {
+	query := `UPDATE tus_uploads SET upload_offset = ?, pending = ?, link_id = ? WHERE id = ?`
+	_, err := s.db.Exec(query, u.Offset, u.Pending, u.LinkID, u.ID)
+	return err
+}
+
+// DeleteTusUpload removes a resumable upload
+func (s *Storage) DeleteTusUpload(id string) error {
+	_, err := s.db.Exec(`DELETE FROM tus_uploads WHERE id = ?`, id)
+	return err
+}
+
+// DeleteExpiredTusUploads removes uploads created before the given time, with their pending
+// parts, and returns how many there were
+func (s *Storage) DeleteExpiredTusUploads(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM tus_uploads WHERE created_at < ?`, before.UTC().Format(auditTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunTusExpiry deletes uploads older than TusUploadTTL every hour until ctx is done
+func (s *Storage) RunTusExpiry(ctx context.Context) {
+	ticker := time.NewTicker(tusSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.DeleteExpiredTusUploads(time.Now().Add(-TusUploadTTL))
+		if err != nil {
+			log.Printf("⚠️ Failed to delete expired tus uploads: %v", err)
+		} else if n > 0 {
+			log.Printf("🧹 Deleted %d expired tus upload(s)", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when a new link would take a user over a quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError explains which quota a new link would exceed. It matches ErrQuotaExceeded.
+type QuotaError struct {
+	Reason string
+}
+
+func (e *QuotaError) Error() string {
+	return ErrQuotaExceeded.Error() + ": " + e.Reason
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Usage is what a user has consumed
+type Usage struct {
+	Links       int64 // Links owned
+	LinkedBytes int64 // Total size of the files behind them
+	EgressBytes int64 // Downloaded from the user's links this month
+}
+
+// Quotas caps what a user may consume, 0 meaning unlimited
+type Quotas struct {
+	MaxLinks       int64
+	MaxLinkedBytes int64
+	MonthlyEgress  int64 // Bytes; downloads are throttled once it's used up
+}
+
+// CheckNewLink returns a *QuotaError if a link to a file of size bytes would take usage over the quotas
+func (q Quotas) CheckNewLink(usage *Usage, size int64) error {
+	if q.MaxLinks > 0 && usage.Links+1 > q.MaxLinks {
+		return &QuotaError{Reason: fmt.Sprintf("the limit of %d links is reached", q.MaxLinks)}
+	}
+	if q.MaxLinkedBytes > 0 && usage.LinkedBytes+size > q.MaxLinkedBytes {
+		return &QuotaError{Reason: "the file would take your linked files over the size limit"}
+	}
+	return nil
+}
+
+// EgressExceeded reports whether the user's monthly download allowance is used up
+func (q Quotas) EgressExceeded(usage *Usage) bool {
+	return q.MonthlyEgress > 0 && usage.EgressBytes >= q.MonthlyEgress
+}
+
+// usageMonth returns the accounting month of t, like "2024-05"
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// GetUsage returns a user's links, linked bytes and egress this month
+func (s *Storage) GetUsage(userID int64) (*Usage, error) {
+	var usage Usage
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(file_size), 0) FROM files WHERE owner_id = ?`, userID).
+		Scan(&usage.Links, &usage.LinkedBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRow(`SELECT COALESCE(SUM(egress_bytes), 0) FROM user_usage WHERE user_id = ? AND month = ?`,
+		userID, usageMonth(time.Now())).Scan(&usage.EgressBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// addEgress adds downloaded bytes to a user's egress this month
+func addEgress(db execer, userID int64, bytes int64) error {
+	query := `INSERT INTO user_usage (user_id, month, egress_bytes) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, month) DO UPDATE SET egress_bytes = egress_bytes + excluded.egress_bytes`
+	_, err := db.Exec(query, userID, usageMonth(time.Now()), bytes)
+	return err
+}
+
+// Totals sums up the whole service
+type Totals struct {
+	Links       int64
+	Users       int64 // Users owning at least one link
+	LinkedBytes int64
+	EgressBytes int64 // Downloaded from links with an owner, all time
+}
+
+// GetTotals returns the service-wide link, user and egress totals
+func (s *Storage) GetTotals() (*Totals, error) {
+	var totals Totals
+	err := s.db.QueryRow(`SELECT COUNT(*), COUNT(DISTINCT NULLIF(owner_id, 0)), COALESCE(SUM(file_size), 0) FROM files`).
+		Scan(&totals.Links, &totals.Users, &totals.LinkedBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.QueryRow(`SELECT COALESCE(SUM(egress_bytes), 0) FROM user_usage`).Scan(&totals.EgressBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &totals, nil
+}
+
+// ListUserIDs returns every user who has owned a link or changed a setting
+func (s *Storage) ListUserIDs() ([]int64, error) {
+	rows, err := s.db.Query(`SELECT owner_id FROM files WHERE owner_id != 0
+		UNION SELECT user_id FROM user_settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteFilesByOwner revokes all of a user's links and their download stats on behalf of actorID,
+// and returns the revoked link IDs. The purge and every revoked link are audited, or nothing is deleted.
+func (s *Storage) DeleteFilesByOwner(ownerID, actorID int64) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	const links = `SELECT link_id FROM files WHERE owner_id = ?`
+	rows, err := tx.Query(links, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	var linkIDs []string
+	for rows.Next() {
+		var linkID string
+		if err := rows.Scan(&linkID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		linkIDs = append(linkIDs, linkID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := deleteLinkStats(tx, links, ownerID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM files WHERE owner_id = ?`, ownerID); err != nil {
+		return nil, err
+	}
+
+	owner := strconv.FormatInt(ownerID, 10)
+	entry := &AuditEntry{ActorID: actorID, Action: AuditPurge, Target: owner, Detail: fmt.Sprintf("%d link(s)", len(linkIDs))}
+	if err := addAudit(tx, entry); err != nil {
+		return nil, err
+	}
+	for _, linkID := range linkIDs {
+		entry := &AuditEntry{ActorID: actorID, Action: AuditRevoke, Target: linkID, Detail: "purge of user " + owner}
+		if err := addAudit(tx, entry); err != nil {
+			return nil, err
+		}
+	}
+	return linkIDs, tx.Commit()
+}
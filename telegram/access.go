@@ -0,0 +1,271 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/telegram/message/markup"
+	"github.com/gotd/td/tg"
+
+	"tele-bot/storage"
+)
+
+// Access modes
+const (
+	AccessOpen     = "open"     // Anyone not denied may use the bot
+	AccessApproval = "approval" // Only allowed users and chats; others ask the admins for access
+)
+
+// maxPendingShown is how many access requests /pending lists
+const maxPendingShown = 20
+
+// AccessControl decides who may use the bot. Subjects are user or chat IDs and @usernames.
+// Rules added at runtime with /allow and /deny take precedence over these.
+type AccessControl struct {
+	Mode   string
+	Admins []int64 // Always allowed, and may change the rules
+	Allow  []string
+	Deny   []string
+}
+
+// SetAccessControl restricts who may use the bot
+func (h *Handler) SetAccessControl(cfg AccessControl) {
+	h.access = cfg
+	h.policy = NewAccessPolicy(cfg)
+	h.admins = h.policy.Admins
+}
+
+// NewAccessPolicy turns the access configuration into the policy storage checks users against,
+// so the HTTP API can apply the same rules as the bot
+func NewAccessPolicy(cfg AccessControl) storage.AccessPolicy {
+	policy := storage.AccessPolicy{
+		Approval: cfg.Mode == AccessApproval,
+		Admins:   make(map[int64]bool, len(cfg.Admins)),
+		Rules:    make(map[string]string),
+	}
+	for _, id := range cfg.Admins {
+		policy.Admins[id] = true
+	}
+	for _, subject := range cfg.Allow {
+		if s, ok := accessSubject(subject); ok {
+			policy.Rules[s] = storage.AccessAllow
+		}
+	}
+	// Deny wins when a subject is in both lists
+	for _, subject := range cfg.Deny {
+		if s, ok := accessSubject(subject); ok {
+			policy.Rules[s] = storage.AccessDeny
+		}
+	}
+	return policy
+}
+
+// accessSubject normalizes an ID or @username, reporting false for anything else
+func accessSubject(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if name, ok := strings.CutPrefix(s, "@"); ok {
+		if name == "" {
+			return "", false
+		}
+		return "@" + strings.ToLower(name), true
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id == 0 {
+		return "", false
+	}
+	return strconv.FormatInt(BareChannelID(id), 10), true
+}
+
+// checkAccess applies the access rules to a message's sender, their username and the chat.
+// A deny rule for any of them wins over allow rules for the others.
+func (h *Handler) checkAccess(msg *tg.Message, entities tg.Entities) storage.AccessDecision {
+	userID := senderID(msg)
+
+	var subjects []string
+	if user, ok := entities.Users[userID]; ok && user.Username != "" {
+		subjects = append(subjects, "@"+strings.ToLower(user.Username))
+	}
+	switch p := msg.PeerID.(type) {
+	case *tg.PeerChat:
+		subjects = append(subjects, strconv.FormatInt(p.ChatID, 10))
+	case *tg.PeerChannel:
+		subjects = append(subjects, strconv.FormatInt(p.ChannelID, 10))
+	}
+	return h.storage.CheckAccess(h.policy, userID, subjects...)
+}
+
+// requestAccess records an unknown user's request for access and tells the admins about it
+func (h *Handler) requestAccess(ctx context.Context, msg *tg.Message, entities tg.Entities) error {
+	// Requests are only made from private chats, groups are left alone
+	user, ok := msg.PeerID.(*tg.PeerUser)
+	if !ok {
+		return nil
+	}
+
+	req := &storage.AccessRequest{UserID: user.UserID}
+	if u, ok := entities.Users[user.UserID]; ok {
+		req.Username = u.Username
+		req.Name = strings.TrimSpace(u.FirstName + " " + u.LastName)
+	}
+
+	created, err := h.storage.CreateAccessRequest(req)
+	if err != nil {
+		log.Printf("❌ Failed to save access request: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to request access. Please try again.")
+	}
+	if !created {
+		return h.reply(ctx, msg, "⏳ Your access request is still waiting for an admin.")
+	}
+
+	log.Printf("🔐 Access requested by user %d", user.UserID)
+	for admin := range h.admins {
+		peer := &tg.InputPeerUser{UserID: admin, AccessHash: accessHash(&h.userHashes, admin)}
+		_, err := h.sender.To(peer).Markup(accessButtons(req.UserID)).Text(ctx,
+			fmt.Sprintf("🔐 *Access request* from %s", describeRequest(req)))
+		if err != nil {
+			log.Printf("⚠️ Failed to notify admin %d of access request: %v", admin, err)
+		}
+	}
+	return h.reply(ctx, msg, "🔒 This bot is private. Your request for access was sent to the admins, "+
+		"you'll get a message once it's decided.")
+}
+
+// accessButtons returns the approve/deny buttons for an access request
+func accessButtons(userID int64) tg.ReplyMarkupClass {
+	return markup.InlineRow(
+		markup.Callback("✅ Approve", []byte(fmt.Sprintf("access:%s:%d", storage.AccessAllow, userID))),
+		markup.Callback("🚫 Deny", []byte(fmt.Sprintf("access:%s:%d", storage.AccessDeny, userID))),
+	)
+}
+
+// describeRequest names the user behind an access request
+func describeRequest(req *storage.AccessRequest) string {
+	text := strconv.FormatInt(req.UserID, 10)
+	if req.Name != "" {
+		text = req.Name + " (" + text + ")"
+	}
+	if req.Username != "" {
+		text += " @" + req.Username
+	}
+	return text
+}
+
+// onAccessButton handles presses of the approve/deny buttons of access requests, data being "<rule>:<user_id>"
+func (h *Handler) onAccessButton(ctx context.Context, q *tg.UpdateBotCallbackQuery, data string) (string, error) {
+	if !h.admins[q.UserID] {
+		return "🚫 Only admins can do that.", nil
+	}
+	rule, subject, _ := strings.Cut(data, ":")
+
+	result, err := h.decideAccess(ctx, q.UserID, subject, rule)
+	if err != nil {
+		return "", err
+	}
+
+	// Replace the request with the decision, dropping the buttons
+	if peer := h.inputPeer(q.Peer); peer != nil {
+		if _, err := h.sender.To(peer).Edit(q.MsgID).Text(ctx, result); err != nil {
+			log.Printf("⚠️ Failed to update access request message: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// decideAccess stores an admin's allow or deny rule for a subject and, if it's a user with a
+// pending request, settles the request and lets them know
+func (h *Handler) decideAccess(ctx context.Context, adminID int64, subject, rule string) (string, error) {
+	subject, ok := accessSubject(subject)
+	if !ok || (rule != storage.AccessAllow && rule != storage.AccessDeny) {
+		return "", fmt.Errorf("invalid access rule %q for %q", rule, subject)
+	}
+	if err := h.storage.SetAccessRule(subject, rule, adminID); err != nil {
+		return "", err
+	}
+	action := storage.AuditAllow
+	if rule == storage.AccessDeny {
+		action = storage.AuditDeny
+	}
+	h.audit(adminID, action, subject, "")
+	log.Printf("🔐 Admin %d set %s for %s", adminID, rule, subject)
+
+	verb := "✅ Allowed"
+	if rule == storage.AccessDeny {
+		verb = "🚫 Denied"
+	}
+	result := fmt.Sprintf("%s %s", verb, subject)
+
+	userID, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		return result, nil
+	}
+	req, err := h.storage.GetAccessRequest(userID)
+	if err != nil || req == nil {
+		return result, err
+	}
+	if err := h.storage.DeleteAccessRequest(userID); err != nil {
+		return "", err
+	}
+	result = fmt.Sprintf("%s %s", verb, describeRequest(req))
+
+	text := "✅ Your access request was approved! Send me a file to get started."
+	if rule == storage.AccessDeny {
+		text = "🚫 Your access request was declined."
+	}
+	peer := &tg.InputPeerUser{UserID: userID, AccessHash: accessHash(&h.userHashes, userID)}
+	if _, err := h.sender.To(peer).Text(ctx, text); err != nil {
+		log.Printf("⚠️ Failed to tell user %d about their access request: %v", userID, err)
+	}
+	return result, nil
+}
+
+// cmdAccessRule handles /allow and /deny, which admins use to allow or deny a user ID, chat ID or @username
+func (h *Handler) cmdAccessRule(ctx context.Context, msg *tg.Message, rule string, args []string) error {
+	if !h.requireAdmin(ctx, msg) {
+		return nil
+	}
+	if len(args) != 1 {
+		return h.reply(ctx, msg, fmt.Sprintf("Usage: /%s `<user_id | chat_id | @username>`", rule))
+	}
+	if _, ok := accessSubject(args[0]); !ok {
+		return h.reply(ctx, msg, "⚠️ Give a numeric user or chat ID, or an @username.")
+	}
+
+	result, err := h.decideAccess(ctx, senderID(msg), args[0], rule)
+	if err != nil {
+		log.Printf("❌ Failed to save access rule: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to save the rule. Please try again.")
+	}
+	return h.reply(ctx, msg, result)
+}
+
+// cmdPending lists the pending access requests, each with approve/deny buttons
+func (h *Handler) cmdPending(ctx context.Context, msg *tg.Message) error {
+	if !h.requireAdmin(ctx, msg) {
+		return nil
+	}
+
+	requests, err := h.storage.ListAccessRequests(maxPendingShown)
+	if err != nil {
+		log.Printf("❌ Failed to list access requests: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to load requests. Please try again.")
+	}
+	if len(requests) == 0 {
+		return h.reply(ctx, msg, "📭 No pending access requests.")
+	}
+
+	peer := h.getPeerFromMessage(msg)
+	if peer == nil {
+		return nil
+	}
+	for _, req := range requests {
+		_, err := h.sender.To(peer).Markup(accessButtons(req.UserID)).Text(ctx,
+			fmt.Sprintf("🔐 %s, waiting since %s", describeRequest(req), req.CreatedAt.Format("2006-01-02 15:04")))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tele-bot/storage"
+)
+
+// broadcastInterval paces broadcasts below Telegram's limit of about 30 messages per second
+const broadcastInterval = 50 * time.Millisecond
+
+// ServiceStats are live figures from the HTTP server
+type ServiceStats struct {
+	ActiveDownloads int64
+	BytesServed     int64 // Since the server started
+}
+
+// SetServiceStats lets /stats show the HTTP server's live figures
+func (h *Handler) SetServiceStats(stats func() ServiceStats) {
+	h.serviceStats = stats
+}
+
+// requireAdmin replies and returns false unless the message is from an admin
+func (h *Handler) requireAdmin(ctx context.Context, msg *tg.Message) bool {
+	if h.admins[senderID(msg)] {
+		return true
+	}
+	h.reply(ctx, msg, "🚫 Only admins can do that.")
+	return false
+}
+
+// audit records an admin action, logging rather than failing the action if it can't
+func (h *Handler) audit(actorID int64, action, target, detail string) {
+	entry := &storage.AuditEntry{ActorID: actorID, Action: action, Target: target, Detail: detail}
+	if err := h.storage.AddAudit(entry); err != nil {
+		log.Printf("⚠️ Failed to audit %s by %d: %v", action, actorID, err)
+	}
+}
+
+// linkIDFromArg accepts a link ID or any of the service's URLs for it
+func linkIDFromArg(arg string) string {
+	arg, _, _ = strings.Cut(arg, "?")
+	arg = strings.TrimRight(arg, "/")
+	if i := strings.LastIndexByte(arg, '/'); i >= 0 {
+		arg = arg[i+1:]
+	}
+	return arg
+}
+
+// cmdStats shows a link's downloads with /stats <link>, or service-wide totals to admins
+func (h *Handler) cmdStats(ctx context.Context, msg *tg.Message, args []string) error {
+	if len(args) > 0 {
+		return h.cmdLinkStats(ctx, msg, args[0])
+	}
+	if !h.admins[senderID(msg)] {
+		return h.reply(ctx, msg, "Usage: /stats `<link_id | link>`")
+	}
+
+	totals, err := h.storage.GetTotals()
+	if err != nil {
+		log.Printf("❌ Failed to load totals: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to load stats. Please try again.")
+	}
+	h.audit(senderID(msg), storage.AuditStats, "", "")
+
+	text := fmt.Sprintf("📈 *Service stats*\n\n"+
+		"🔗 Links: %d\n"+
+		"👥 Users: %d\n"+
+		"💾 Linked files: %s\n"+
+		"📤 Served to owners' links: %s",
+		totals.Links, totals.Users, FormatFileSize(totals.LinkedBytes), FormatFileSize(totals.EgressBytes))
+	if h.serviceStats != nil {
+		live := h.serviceStats()
+		text += fmt.Sprintf("\n📡 Served since start: %s\n"+
+			"▶️ Active downloads: %d",
+			FormatFileSize(live.BytesServed), live.ActiveDownloads)
+	}
+	return h.reply(ctx, msg, text)
+}
+
+// cmdBroadcast sends the text after the command to every user of the bot. Sending runs in the
+// background and the admin is told how it went once it's done.
+func (h *Handler) cmdBroadcast(ctx context.Context, msg *tg.Message) error {
+	if !h.requireAdmin(ctx, msg) {
+		return nil
+	}
+
+	_, text, _ := strings.Cut(strings.TrimSpace(msg.Message), " ")
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return h.reply(ctx, msg, "Usage: /broadcast `<message>`")
+	}
+
+	users, err := h.storage.ListUserIDs()
+	if err != nil {
+		log.Printf("❌ Failed to list users: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to load users. Please try again.")
+	}
+	adminID := senderID(msg)
+	h.audit(adminID, storage.AuditBroadcast, "", fmt.Sprintf("%d user(s): %s", len(users), text))
+
+	log.Printf("📣 Admin %d is broadcasting to %d user(s)", adminID, len(users))
+	admin := h.getPeerFromMessage(msg)
+	go func() {
+		sent := 0
+		for _, userID := range users {
+			peer := &tg.InputPeerUser{UserID: userID, AccessHash: accessHash(&h.userHashes, userID)}
+			if _, err := h.sender.To(peer).Text(h.ctx, text); err != nil {
+				log.Printf("⚠️ Broadcast to user %d failed: %v", userID, err)
+			} else {
+				sent++
+			}
+
+			select {
+			case <-time.After(broadcastInterval):
+			case <-h.ctx.Done():
+				return
+			}
+		}
+		log.Printf("📣 Broadcast reached %d of %d user(s)", sent, len(users))
+		if _, err := h.sender.To(admin).Text(h.ctx, fmt.Sprintf("📣 Broadcast sent to %d of %d user(s).", sent, len(users))); err != nil {
+			log.Printf("⚠️ Failed to report broadcast: %v", err)
+		}
+	}()
+	return h.reply(ctx, msg, fmt.Sprintf("📣 Sending to %d user(s)...", len(users)))
+}
+
+// cmdBan makes the bot ignore a user, or with unban lets them back in
+func (h *Handler) cmdBan(ctx context.Context, msg *tg.Message, unban bool, args []string) error {
+	if !h.requireAdmin(ctx, msg) {
+		return nil
+	}
+
+	command := "ban"
+	if unban {
+		command = "unban"
+	}
+	if len(args) != 1 {
+		return h.reply(ctx, msg, fmt.Sprintf("Usage: /%s `<user_id | @username>`", command))
+	}
+	subject, ok := accessSubject(args[0])
+	if !ok {
+		return h.reply(ctx, msg, "⚠️ Give a numeric user ID or an @username.")
+	}
+	if id, err := strconv.ParseInt(subject, 10, 64); err == nil && h.admins[id] {
+		return h.reply(ctx, msg, "⚠️ Admins can't be banned.")
+	}
+
+	adminID := senderID(msg)
+	if unban {
+		// Only a stored deny is lifted, an allow added with /allow stays
+		rule, err := h.storage.GetAccessRule(subject)
+		if err == nil && rule == storage.AccessDeny {
+			_, err = h.storage.DeleteAccessRule(subject)
+		}
+		if err != nil {
+			log.Printf("❌ Failed to unban %s: %v", subject, err)
+			return h.reply(ctx, msg, "❌ Failed to unban. Please try again.")
+		}
+		if rule != storage.AccessDeny {
+			return h.reply(ctx, msg, fmt.Sprintf("ℹ️ %s isn't banned.", subject))
+		}
+		h.audit(adminID, storage.AuditUnban, subject, "")
+		log.Printf("🔓 Admin %d unbanned %s", adminID, subject)
+		return h.reply(ctx, msg, fmt.Sprintf("🔓 Unbanned %s.", subject))
+	}
+
+	if err := h.storage.SetAccessRule(subject, storage.AccessDeny, adminID); err != nil {
+		log.Printf("❌ Failed to ban %s: %v", subject, err)
+		return h.reply(ctx, msg, "❌ Failed to ban. Please try again.")
+	}
+
+	// API tokens would otherwise keep uploads and the HTTP API open to them
+	var detail string
+	if id, err := strconv.ParseInt(subject, 10, 64); err == nil {
+		revoked, err := h.storage.RevokeAPITokens(id)
+		if err != nil {
+			log.Printf("❌ Failed to revoke API tokens of %d: %v", id, err)
+			h.audit(adminID, storage.AuditBan, subject, "")
+			return h.reply(ctx, msg, fmt.Sprintf("⚠️ Banned %s, but failed to revoke their API tokens. Please ban again.", subject))
+		}
+		detail = fmt.Sprintf("%d API token(s) revoked", revoked)
+	}
+	h.audit(adminID, storage.AuditBan, subject, detail)
+	log.Printf("🔨 Admin %d banned %s", adminID, subject)
+	if detail != "" {
+		return h.reply(ctx, msg, fmt.Sprintf("🔨 Banned %s, %s. Their links keep working, use /purge to revoke them.", subject, detail))
+	}
+	return h.reply(ctx, msg, fmt.Sprintf("🔨 Banned %s. Their links keep working, use /purge to revoke them.", subject))
+}
+
+// cmdLookup shows who owns a link and where its file came from
+func (h *Handler) cmdLookup(ctx context.Context, msg *tg.Message, args []string) error {
+	if !h.requireAdmin(ctx, msg) {
+		return nil
+	}
+	if len(args) != 1 {
+		return h.reply(ctx, msg, "Usage: /lookup `<link_id | link>`")
+	}
+
+	linkID := linkIDFromArg(args[0])
+	meta, err := h.storage.GetFileByLink(linkID)
+	if err != nil {
+		log.Printf("❌ Failed to look up %s: %v", linkID, err)
+		return h.reply(ctx, msg, "❌ Failed to look up the link. Please try again.")
+	}
+	if meta == nil {
+		return h.reply(ctx, msg, fmt.Sprintf("🔍 No link `%s`.", linkID))
+	}
+	h.audit(senderID(msg), storage.AuditLookup, linkID, "")
+
+	owner := "unknown"
+	if meta.OwnerID != 0 {
+		owner = strconv.FormatInt(meta.OwnerID, 10)
+	}
+	origin := "unknown"
+	switch {
+	case meta.SourceChannelID != 0:
+		origin = fmt.Sprintf("post %d in channel %d", meta.SourceMessageID, meta.SourceChannelID)
+	case meta.SourceMessageID != 0:
+		origin = fmt.Sprintf("message %d in the owner's chat with the bot", meta.SourceMessageID)
+	}
+
+	text := fmt.Sprintf("🔍 *Link* `%s`\n\n"+
+		"📄 %s (%s)\n"+
+		"👤 Owner: %s\n"+
+		"📍 Origin: %s\n"+
+		"🕐 Created: %s",
+		meta.LinkID, meta.FileName, FormatFileSize(meta.FileSize), owner, origin,
+		meta.CreatedAt.Format("2006-01-02 15:04"))
+	if meta.Collection != "" {
+		text += fmt.Sprintf("\n📚 Collection: %s", meta.Collection)
+	}
+	if meta.ExpiresAt != nil {
+		text += fmt.Sprintf("\n⏳ Expires: %s", meta.ExpiresAt.Format("2006-01-02 15:04"))
+	}
+	if meta.PasswordHash != "" {
+		text += "\n🔒 Password protected"
+	}
+	return h.reply(ctx, msg, text)
+}
+
+// cmdPurge revokes all of a user's links
+func (h *Handler) cmdPurge(ctx context.Context, msg *tg.Message, args []string) error {
+	if !h.requireAdmin(ctx, msg) {
+		return nil
+	}
+	if len(args) != 1 {
+		return h.reply(ctx, msg, "Usage: /purge `<user_id>`")
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || userID <= 0 {
+		return h.reply(ctx, msg, "⚠️ Give a numeric user ID.")
+	}
+
+	adminID := senderID(msg)
+	linkIDs, err := h.storage.DeleteFilesByOwner(userID, adminID)
+	if err != nil {
+		log.Printf("❌ Failed to purge links of user %d: %v", userID, err)
+		return h.reply(ctx, msg, "❌ Failed to revoke the links. Please try again.")
+	}
+	n := len(linkIDs)
+	log.Printf("🗑 Admin %d revoked %d link(s) of user %d", adminID, n, userID)
+	return h.reply(ctx, msg, fmt.Sprintf("🗑 Revoked %d link(s) of user %d.", n, userID))
+}
@@ -0,0 +1,139 @@
+package telegram
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// chunkKey identifies a chunk of a file by its aligned offset
+type chunkKey struct {
+	fileID int64
+	offset int64
+	limit  int64
+}
+
+// CacheStats is a snapshot of the chunk cache
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Coalesced int64 `json:"coalesced"` // Misses that waited for another request's download
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+}
+
+// ChunkCache is a size-bounded LRU cache of downloaded chunks shared by all readers.
+// Concurrent misses for the same chunk are coalesced into a single download.
+type ChunkCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List // Front is most recently used
+	entries  map[chunkKey]*list.Element
+	inflight map[chunkKey]*chunkCall
+	stats    CacheStats
+}
+
+// cacheEntry is a cached chunk
+type cacheEntry struct {
+	key  chunkKey
+	data []byte
+}
+
+// chunkCall is a download other readers of the same chunk wait on
+type chunkCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// NewChunkCache creates a cache holding up to maxBytes of chunk data
+func NewChunkCache(maxBytes int64) *ChunkCache {
+	return &ChunkCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[chunkKey]*list.Element),
+		inflight: make(map[chunkKey]*chunkCall),
+	}
+}
+
+// get returns a chunk from the cache, or downloads it with fetch. The returned slice is
+// shared and must not be modified.
+func (c *ChunkCache) get(ctx context.Context, key chunkKey, fetch func() ([]byte, error)) ([]byte, error) {
+	for {
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return el.Value.(*cacheEntry).data, nil
+		}
+
+		if call, ok := c.inflight[key]; ok {
+			c.stats.Coalesced++
+			c.mu.Unlock()
+
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// The leader's client going away isn't our failure - try again
+			if errors.Is(call.err, context.Canceled) && ctx.Err() == nil {
+				continue
+			}
+			return call.data, call.err
+		}
+
+		call := &chunkCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		c.stats.Misses++
+		c.mu.Unlock()
+
+		call.data, call.err = fetch()
+
+		c.mu.Lock()
+		delete(c.inflight, key)
+		if call.err == nil {
+			c.addLocked(key, call.data)
+		}
+		c.mu.Unlock()
+		close(call.done)
+
+		return call.data, call.err
+	}
+}
+
+// addLocked stores a chunk, evicting the least recently used ones to stay within budget
+func (c *ChunkCache) addLocked(key chunkKey, data []byte) {
+	size := int64(len(data))
+	if size == 0 || size > c.maxBytes {
+		return
+	}
+
+	for c.bytes+size > c.maxBytes {
+		oldest := c.lru.Back()
+		entry := c.lru.Remove(oldest).(*cacheEntry)
+		delete(c.entries, entry.key)
+		c.bytes -= int64(len(entry.data))
+		c.stats.Evictions++
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data})
+	c.bytes += size
+}
+
+// Stats returns a snapshot of the cache
+func (c *ChunkCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
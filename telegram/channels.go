@@ -0,0 +1,381 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+	"unicode/utf16"
+
+	"github.com/google/uuid"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+
+	"tele-bot/storage"
+)
+
+const (
+	// backfillBatch is the number of messages fetched per history request
+	backfillBatch = 100
+
+	// backfillDelay spaces out history requests to stay clear of flood limits
+	backfillDelay = 500 * time.Millisecond
+
+	// maxCaptionLength is Telegram's limit on media captions, in UTF-16 code units
+	maxCaptionLength = 1024
+)
+
+// errNeedMaxID is returned when history can't be read and message IDs have to be scanned instead
+var errNeedMaxID = errors.New("bots can't read channel history; pass the ID of the newest post to scan from")
+
+// ChannelIndexing configures automatic links for every file posted in watched channels
+type ChannelIndexing struct {
+	Channels          []int64 // Channel IDs to index, bare or "-100" prefixed
+	ReplyInDiscussion bool    // Reply with the link under the post in the linked discussion group
+	EditPosts         bool    // Append the link to the post's caption
+}
+
+// SetChannelIndexing enables channel indexing mode
+func (h *Handler) SetChannelIndexing(cfg ChannelIndexing) {
+	h.indexing = cfg
+	h.watched = make(map[int64]bool, len(cfg.Channels))
+	for _, id := range cfg.Channels {
+		h.watched[BareChannelID(id)] = true
+	}
+}
+
+// onChannelMessage links files posted in watched channels
+func (h *Handler) onChannelMessage(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
+	msg, ok := u.Message.(*tg.Message)
+	if !ok {
+		return nil
+	}
+	peer, ok := msg.PeerID.(*tg.PeerChannel)
+	if !ok {
+		return nil
+	}
+
+	// Allowed supergroups of a user account are handled like private chats
+	if h.userMode && !h.watched[peer.ChannelID] && h.allowedInUserMode(msg) {
+		return h.onMessage(ctx, e, msg)
+	}
+
+	if msg.Media == nil || msg.Out || !h.watched[peer.ChannelID] {
+		return nil
+	}
+	messagesHandled.WithLabelValues("channel_post").Inc()
+
+	channel := &tg.InputPeerChannel{ChannelID: peer.ChannelID}
+	if c, ok := e.Channels[peer.ChannelID]; ok {
+		channel.AccessHash = c.AccessHash
+	}
+
+	meta, err := h.indexPost(channel, msg)
+	if err != nil || meta == nil {
+		return err
+	}
+
+	h.announceLink(ctx, channel, msg, meta)
+	return nil
+}
+
+// indexPost creates a link for a channel post, unless it has one already or holds no file.
+// It returns nil for posts that weren't linked.
+func (h *Handler) indexPost(channel *tg.InputPeerChannel, msg *tg.Message) (*storage.FileMetadata, error) {
+	existing, err := h.storage.GetFileBySource(channel.ChannelID, msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, nil
+	}
+
+	meta, err := ExtractFileMetadata(msg.Media)
+	if err != nil || meta == nil {
+		// Unsupported media in a channel is simply skipped
+		return nil, nil
+	}
+
+	meta.LinkID = uuid.New().String()
+	meta.SourceChannelID = channel.ChannelID
+	meta.SourceAccessHash = channel.AccessHash
+	meta.SourceMessageID = msg.ID
+
+	if err := h.storage.SaveFile(meta); err != nil {
+		log.Printf("❌ Failed to save file metadata: %v", err)
+		return nil, err
+	}
+
+	log.Printf("📢 Indexed channel %d post %d: %s", channel.ChannelID, msg.ID, meta.FileName)
+	return meta, nil
+}
+
+// announceLink publishes the link of an indexed post as configured: edited into the caption,
+// as a reply in the discussion group, or both
+func (h *Handler) announceLink(ctx context.Context, channel *tg.InputPeerChannel, msg *tg.Message, meta *storage.FileMetadata) {
+	link := fmt.Sprintf("%s/download/%s", h.baseURL, meta.LinkID)
+
+	if h.indexing.EditPosts {
+		if err := h.appendLinkToPost(ctx, channel, msg, link); err != nil {
+			log.Printf("⚠️ Failed to edit channel post %d: %v", msg.ID, err)
+		}
+	}
+
+	if h.indexing.ReplyInDiscussion {
+		if err := h.replyInDiscussion(ctx, channel, msg, link); err != nil {
+			log.Printf("⚠️ Failed to reply to channel post %d: %v", msg.ID, err)
+		}
+	}
+}
+
+// appendLinkToPost adds the download link to the end of a post's caption
+func (h *Handler) appendLinkToPost(ctx context.Context, channel *tg.InputPeerChannel, msg *tg.Message, link string) error {
+	caption := msg.Message
+	if caption != "" {
+		caption += "\n\n"
+	}
+	caption += "🔗 " + link
+
+	if len(utf16.Encode([]rune(caption))) > maxCaptionLength {
+		return errors.New("caption would exceed the length limit")
+	}
+
+	// Offsets of the existing entities stay valid since the link is appended
+	_, err := h.api.MessagesEditMessage(ctx, &tg.MessagesEditMessageRequest{
+		Peer:     channel,
+		ID:       msg.ID,
+		Message:  caption,
+		Entities: msg.Entities,
+	})
+	return err
+}
+
+// replyInDiscussion comments on a post in the channel's linked discussion group
+func (h *Handler) replyInDiscussion(ctx context.Context, channel *tg.InputPeerChannel, msg *tg.Message, link string) error {
+	discussion, err := h.api.MessagesGetDiscussionMessage(ctx, &tg.MessagesGetDiscussionMessageRequest{
+		Peer:  channel,
+		MsgID: msg.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range discussion.Messages {
+		thread, ok := m.(*tg.Message)
+		if !ok {
+			continue
+		}
+		group, ok := thread.PeerID.(*tg.PeerChannel)
+		if !ok {
+			continue
+		}
+
+		peer := &tg.InputPeerChannel{ChannelID: group.ChannelID}
+		for _, chat := range discussion.Chats {
+			if c, ok := chat.(*tg.Channel); ok && c.ID == group.ChannelID {
+				peer.AccessHash = c.AccessHash
+			}
+		}
+
+		_, err := h.sender.To(peer).Reply(thread.ID).Text(ctx, "🔗 Download link:\n"+link)
+		return err
+	}
+
+	return errors.New("post has no discussion thread")
+}
+
+// cmdBackfill indexes older posts of a watched channel: /backfill <channel_id> [newest_post_id]
+func (h *Handler) cmdBackfill(ctx context.Context, msg *tg.Message, entities tg.Entities, args []string) error {
+	if len(args) == 0 {
+		return h.reply(ctx, msg, "Usage: /backfill `<channel_id>` `[newest_post_id]`")
+	}
+
+	channelID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || !h.watched[BareChannelID(channelID)] {
+		return h.reply(ctx, msg, "⚠️ That channel isn't in the indexing allowlist.")
+	}
+
+	var maxID int
+	if len(args) > 1 {
+		if maxID, err = strconv.Atoi(args[1]); err != nil || maxID < 1 {
+			return h.reply(ctx, msg, "⚠️ The newest post ID must be a positive number.")
+		}
+	}
+
+	channel, err := ResolveChannel(ctx, h.api, channelID)
+	if err != nil {
+		log.Printf("❌ Failed to resolve channel for backfill: %v", err)
+		return h.reply(ctx, msg, "❌ Couldn't access that channel. Is the bot a member?")
+	}
+
+	// Only the channel's admins may trigger a backfill
+	isAdmin, err := h.isChannelAdmin(ctx, channel, inputPeer(msg.PeerID, entities))
+	if err != nil || !isAdmin {
+		return h.reply(ctx, msg, "⛔ Only admins of the channel can backfill it.")
+	}
+
+	if err := h.reply(ctx, msg, "⏳ Indexing older posts, this may take a while..."); err != nil {
+		return err
+	}
+
+	// Run detached from this update so other messages keep being handled
+	go func() {
+		indexed, err := h.backfill(h.ctx, channel, maxID)
+		if err != nil {
+			log.Printf("⚠️ Backfill of channel %d stopped: %v", channel.ChannelID, err)
+			h.reply(h.ctx, msg, fmt.Sprintf("⚠️ Backfill stopped after indexing %d file(s): %v", indexed, err))
+			return
+		}
+		h.reply(h.ctx, msg, fmt.Sprintf("✅ Backfill complete: indexed %d file(s).", indexed))
+	}()
+	return nil
+}
+
+// isChannelAdmin reports whether a user is the creator or an admin of a channel
+func (h *Handler) isChannelAdmin(ctx context.Context, channel *tg.InputPeerChannel, user tg.InputPeerClass) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	res, err := h.api.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+		Channel:     &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+		Participant: user,
+	})
+	if err != nil {
+		return false, err
+	}
+	switch res.Participant.(type) {
+	case *tg.ChannelParticipantCreator, *tg.ChannelParticipantAdmin:
+		return true, nil
+	}
+	return false, nil
+}
+
+// isChannelMember reports whether a user is in a channel, as opposed to having left or been banned from it
+func (h *Handler) isChannelMember(ctx context.Context, channel *tg.InputPeerChannel, user tg.InputPeerClass) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	res, err := h.api.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+		Channel:     &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+		Participant: user,
+	})
+	if tgerr.Is(err, "USER_NOT_PARTICIPANT") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch res.Participant.(type) {
+	case *tg.ChannelParticipantLeft, *tg.ChannelParticipantBanned:
+		return false, nil
+	}
+	return true, nil
+}
+
+// backfill walks a channel's history from maxID (0 for the newest post) down, indexing every file.
+// Bots aren't allowed to read history, so when that fails it scans message IDs with channels.getMessages.
+func (h *Handler) backfill(ctx context.Context, channel *tg.InputPeerChannel, maxID int) (int, error) {
+	indexed := 0
+	index := func(messages []tg.MessageClass) error {
+		for _, m := range messages {
+			if msg, ok := m.(*tg.Message); ok && msg.Media != nil {
+				meta, err := h.indexPost(channel, msg)
+				if err != nil {
+					return err
+				}
+				if meta != nil {
+					indexed++
+				}
+			}
+		}
+		return nil
+	}
+
+	offsetID := maxID
+	if offsetID > 0 {
+		offsetID++ // getHistory returns messages below the offset
+	}
+	for {
+		res, err := h.api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:     channel,
+			OffsetID: offsetID,
+			Limit:    backfillBatch,
+		})
+		if tgerr.Is(err, "BOT_METHOD_INVALID") {
+			if maxID == 0 {
+				return indexed, errNeedMaxID
+			}
+			err := h.scanMessageIDs(ctx, channel, maxID, index)
+			return indexed, err
+		}
+		if wait, ok := tgerr.AsFloodWait(err); ok {
+			observeFloodWait(err)
+			select {
+			case <-ctx.Done():
+				return indexed, ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		if err != nil {
+			return indexed, err
+		}
+
+		modified, ok := res.AsModified()
+		if !ok || len(modified.GetMessages()) == 0 {
+			return indexed, nil
+		}
+		messages := modified.GetMessages()
+		if err := index(messages); err != nil {
+			return indexed, err
+		}
+		offsetID = messages[len(messages)-1].GetID()
+
+		select {
+		case <-ctx.Done():
+			return indexed, ctx.Err()
+		case <-time.After(backfillDelay):
+		}
+	}
+}
+
+// scanMessageIDs fetches posts by ID in batches from maxID down to 1
+func (h *Handler) scanMessageIDs(ctx context.Context, channel *tg.InputPeerChannel, maxID int, index func([]tg.MessageClass) error) error {
+	input := &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash}
+
+	for high := maxID; high > 0; {
+		ids := make([]tg.InputMessageClass, 0, backfillBatch)
+		for id := high; id > 0 && id > high-backfillBatch; id-- {
+			ids = append(ids, &tg.InputMessageID{ID: id})
+		}
+
+		res, err := h.api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{Channel: input, ID: ids})
+		if wait, ok := tgerr.AsFloodWait(err); ok {
+			observeFloodWait(err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if modified, ok := res.AsModified(); ok {
+			if err := index(modified.GetMessages()); err != nil {
+				return err
+			}
+		}
+		high -= backfillBatch
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backfillDelay):
+		}
+	}
+	return nil
+}
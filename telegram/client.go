@@ -10,11 +10,15 @@ import (
 
 	"github.com/gotd/td/session"
 	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// defaultPoolSize is the number of pooled connections when none is configured
+const defaultPoolSize = 8
+
 // CloseInvoker is a pooled invoker that can be closed
 type CloseInvoker interface {
 	tg.Invoker
@@ -25,21 +29,46 @@ type CloseInvoker interface {
 type Client struct {
 	client      *telegram.Client
 	api         *tg.Client
-	pool        CloseInvoker // Connection pool for downloads
-	pooledAPI   *tg.Client   // API client backed by the pool
+	pool        *poolInvoker // Connection pool for downloads
+	poolOpts    PoolOptions
+	pooledAPI   *tg.Client // API client backed by the pool
 	apiID       int
 	apiHash     string
 	sessionPath string
+	phone       string   // Set in user-account mode, empty for bots
+	self        *tg.User // Account the client is signed in as
+	logger      *zap.Logger
+
+	// Extra bot accounts downloads are spread over
+	workerTokens []string
+	workers      *Workers
 }
 
 // NewClient creates a new Telegram client with proper update handling
 func NewClient(apiID int, apiHash, botToken, sessionDir string) (*Client, *tg.UpdateDispatcher, error) {
+	return newClient(apiID, apiHash, sessionDir, "session.json")
+}
+
+// NewUserClient creates a client that signs in as a user account instead of a bot.
+// The login code and 2FA password are asked for on the terminal the first time.
+func NewUserClient(apiID int, apiHash, phone, sessionDir string) (*Client, *tg.UpdateDispatcher, error) {
+	// A separate session file keeps the bot session intact when switching modes
+	c, dispatcher, err := newClient(apiID, apiHash, sessionDir, "user_session.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	c.phone = phone
+	return c, dispatcher, nil
+}
+
+// newClient creates a client that stores its session in sessionFile inside sessionDir
+func newClient(apiID int, apiHash, sessionDir, sessionFile string) (*Client, *tg.UpdateDispatcher, error) {
 	// Create session directory if it doesn't exist
 	if err := os.MkdirAll(sessionDir, 0700); err != nil {
 		return nil, nil, fmt.Errorf("failed to create session directory: %w", err)
 	}
 
-	sessionPath := filepath.Join(sessionDir, "session.json")
+	sessionPath := filepath.Join(sessionDir, sessionFile)
 
 	// Create the dispatcher BEFORE the client
 	dispatcher := tg.NewUpdateDispatcher()
@@ -67,27 +96,47 @@ func NewClient(apiID int, apiHash, botToken, sessionDir string) (*Client, *tg.Up
 		apiID:       apiID,
 		apiHash:     apiHash,
 		sessionPath: sessionPath,
+		logger:      logger,
+		workers:     NewWorkers(),
+		poolOpts:    PoolOptions{Size: defaultPoolSize},
 	}, &dispatcher, nil
 }
 
 // Run starts the client and handles authentication
 func (c *Client) Run(ctx context.Context, botToken string, handler func(*Client) error) error {
 	return c.client.Run(ctx, func(ctx context.Context) error {
-		// Authenticate as bot
+		// Authenticate as bot, or as a user account in user mode
 		status, err := c.client.Auth().Status(ctx)
 		if err != nil {
 			return fmt.Errorf("auth status check failed: %w", err)
 		}
 
 		if !status.Authorized {
-			if _, err := c.client.Auth().Bot(ctx, botToken); err != nil {
-				return fmt.Errorf("bot authentication failed: %w", err)
+			if c.phone != "" {
+				flow := auth.NewFlow(newTerminalAuth(c.phone), auth.SendCodeOptions{})
+				if err := flow.Run(ctx, c.client.Auth()); err != nil {
+					return fmt.Errorf("user authentication failed: %w", err)
+				}
+				log.Println("User account authenticated successfully")
+			} else {
+				if _, err := c.client.Auth().Bot(ctx, botToken); err != nil {
+					return fmt.Errorf("bot authentication failed: %w", err)
+				}
+				log.Println("Bot authenticated successfully")
 			}
-			log.Println("Bot authenticated successfully")
 		} else {
 			log.Println("Already authenticated")
 		}
 
+		self, err := c.client.Self(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get own account: %w", err)
+		}
+		c.self = self
+		if c.phone != "" && self.Bot {
+			return fmt.Errorf("session belongs to a bot; remove %s to sign in as a user", c.sessionPath)
+		}
+
 		// Attempt to read session just to log DC (optional)
 		data, err := os.ReadFile(c.sessionPath)
 		if err == nil {
@@ -100,17 +149,25 @@ func (c *Client) Run(ctx context.Context, botToken string, handler func(*Client)
 		c.api = c.client.API()
 
 		// Initialize Connection Pool for parallel downloads
-		// Create pool - match or exceed your download manager's connection count
-		const maxPoolConnections = 8
-		pool, err := c.client.Pool(maxPoolConnections)
+		// Size it to match or exceed your download manager's connection count
+		pool, err := c.client.Pool(int64(c.poolOpts.Size))
 		if err != nil {
 			log.Printf("⚠️ Failed to create connection pool: %v (falling back to single connection)", err)
 			// Fallback: use standard API for downloads too
 			c.pooledAPI = c.api
 		} else {
-			c.pool = pool
-			c.pooledAPI = tg.NewClient(pool)
-			log.Printf("✅ Connection pool created with max %d connections", maxPoolConnections)
+			c.pool = newPoolInvoker(pool, c.poolOpts)
+			c.pooledAPI = tg.NewClient(c.pool)
+			if c.poolOpts.Adaptive {
+				log.Printf("✅ Adaptive connection pool created with %d-%d connections", c.pool.opts.MinSize, c.poolOpts.Size)
+			} else {
+				log.Printf("✅ Connection pool created with max %d connections", c.poolOpts.Size)
+			}
+		}
+
+		if len(c.workerTokens) > 0 {
+			log.Printf("🤖 Starting %d extra download bot(s)", len(c.workerTokens))
+			c.startWorkers(ctx, c.workerTokens, c.logger)
 		}
 
 		// Run the handler with the full Client (provides access to API and PooledAPI)
@@ -123,6 +180,38 @@ func (c *Client) API() *tg.Client {
 	return c.api
 }
 
+// ConfigurePool sets the size of the download connection pool. Call it before Run.
+func (c *Client) ConfigurePool(opts PoolOptions) {
+	if opts.Size < 1 {
+		opts.Size = defaultPoolSize
+	}
+	c.poolOpts = opts
+}
+
+// PoolStats returns the state of the download connection pool, or false if there is no pool
+func (c *Client) PoolStats() (PoolStats, bool) {
+	if c.pool == nil {
+		return PoolStats{}, false
+	}
+	return c.pool.Stats(), true
+}
+
+// AddDownloadBots signs in extra bot accounts when the client runs, to spread downloads over.
+// Each bot must be a member of the channels files are sourced from to serve them.
+func (c *Client) AddDownloadBots(tokens []string) {
+	c.workerTokens = append(c.workerTokens, tokens...)
+}
+
+// Workers returns the pool of accounts downloads are spread over
+func (c *Client) Workers() *Workers {
+	return c.workers
+}
+
+// Self returns the account the client is signed in as
+func (c *Client) Self() *tg.User {
+	return c.self
+}
+
 // PooledAPI returns the pooled API client for parallel downloads
 // Uses multiple TCP connections for better throughput
 func (c *Client) PooledAPI() *tg.Client {
@@ -0,0 +1,204 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/gotd/td/tg"
+
+	"tele-bot/storage"
+)
+
+// collectionNamePattern restricts collection names to characters that are safe in URLs
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// handleCommand runs a bot command. It reports false for commands it doesn't know.
+func (h *Handler) handleCommand(ctx context.Context, msg *tg.Message, entities tg.Entities) (bool, error) {
+	name, args := parseCommand(msg.Message)
+	log.Printf("🤖 Received /%s command", name)
+
+	switch name {
+	case "start":
+		return true, h.cmdStart(ctx, msg)
+	case "collection":
+		return true, h.cmdCollection(ctx, msg, args)
+	case "token":
+		return true, h.cmdToken(ctx, msg, args)
+	case "backfill":
+		return true, h.cmdBackfill(ctx, msg, entities, args)
+	case "usage":
+		return true, h.cmdUsage(ctx, msg)
+	case "allow":
+		return true, h.cmdAccessRule(ctx, msg, storage.AccessAllow, args)
+	case "deny":
+		return true, h.cmdAccessRule(ctx, msg, storage.AccessDeny, args)
+	case "pending":
+		return true, h.cmdPending(ctx, msg)
+	case "stats":
+		return true, h.cmdStats(ctx, msg, args)
+	case "broadcast":
+		return true, h.cmdBroadcast(ctx, msg)
+	case "ban":
+		return true, h.cmdBan(ctx, msg, false, args)
+	case "unban":
+		return true, h.cmdBan(ctx, msg, true, args)
+	case "lookup":
+		return true, h.cmdLookup(ctx, msg, args)
+	case "purge":
+		return true, h.cmdPurge(ctx, msg, args)
+	}
+
+	return false, nil
+}
+
+// parseCommand splits "/cmd@BotName arg1 arg2" into "cmd" and its arguments
+func parseCommand(text string) (string, []string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	name := strings.TrimPrefix(fields[0], "/")
+	if at := strings.IndexByte(name, '@'); at >= 0 {
+		name = name[:at]
+	}
+	return strings.ToLower(name), fields[1:]
+}
+
+// reply sends a text message back to the chat a message came from
+func (h *Handler) reply(ctx context.Context, msg *tg.Message, text string) error {
+	peer := h.getPeerFromMessage(msg)
+	if peer == nil {
+		return nil
+	}
+	_, err := h.sender.To(peer).Text(ctx, text)
+	if err != nil {
+		log.Printf("❌ Failed to send reply: %v", err)
+	}
+	return err
+}
+
+// cmdStart sends the welcome message
+func (h *Handler) cmdStart(ctx context.Context, msg *tg.Message) error {
+	text := "🎉 *Welcome to File Link Generator Bot!*\n\n" +
+		"Send me any file and I'll generate a download link for you.\n\n" +
+		"Features:\n" +
+		"📁 Documents, PDFs\n" +
+		"🖼 Photos\n" +
+		"🔗 HTTP Range support for resumable downloads\n" +
+		"📢 Paste a t.me post link to link a file from a channel\n" +
+		"📚 /collection `<name>` groups uploads into a playlist\n" +
+		"📊 /usage shows your usage and quotas"
+	if h.admins[senderID(msg)] {
+		text += "\n\n🛠 *Admin:* /stats, /broadcast, /ban, /unban, /lookup, /purge, /allow, /deny, /pending"
+	}
+	err := h.reply(ctx, msg, text)
+	if err == nil {
+		log.Println("✅ Sent /start welcome message")
+	}
+	return err
+}
+
+// cmdCollection sets or clears the collection new uploads are added to
+func (h *Handler) cmdCollection(ctx context.Context, msg *tg.Message, args []string) error {
+	userID := senderID(msg)
+	if userID == 0 {
+		return nil
+	}
+
+	if len(args) == 0 {
+		if err := h.storage.SetActiveCollection(userID, ""); err != nil {
+			log.Printf("❌ Failed to clear collection: %v", err)
+			return h.reply(ctx, msg, "❌ Failed to update collection. Please try again.")
+		}
+		return h.reply(ctx, msg, "📚 Collection cleared. New uploads won't be added to a playlist.\n\n"+
+			"Use /collection `<name>` to start one.")
+	}
+
+	name := args[0]
+	if !collectionNamePattern.MatchString(name) {
+		return h.reply(ctx, msg, "⚠️ Collection names may only contain letters, digits, `-` and `_` (up to 64 characters).")
+	}
+
+	if err := h.storage.SetActiveCollection(userID, name); err != nil {
+		log.Printf("❌ Failed to set collection: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to update collection. Please try again.")
+	}
+
+	return h.reply(ctx, msg, fmt.Sprintf(
+		"📚 New uploads will be added to `%s`.\n\n"+
+			"🎞 *Playlist:*\n%s/playlist/%s.m3u8\n\n"+
+			"Send /collection without a name to stop.",
+		name, h.baseURL, name,
+	))
+}
+
+// cmdToken mints an API token for the REST API, or revokes all of the user's tokens with "/token revoke"
+func (h *Handler) cmdToken(ctx context.Context, msg *tg.Message, args []string) error {
+	userID := senderID(msg)
+	if userID == 0 {
+		return nil
+	}
+
+	if len(args) > 0 && args[0] == "revoke" {
+		n, err := h.storage.RevokeAPITokens(userID)
+		if err != nil {
+			log.Printf("❌ Failed to revoke API tokens: %v", err)
+			return h.reply(ctx, msg, "❌ Failed to revoke tokens. Please try again.")
+		}
+		return h.reply(ctx, msg, fmt.Sprintf("🗑 Revoked %d API token(s).", n))
+	}
+
+	token, err := storage.NewAPIToken()
+	if err == nil {
+		err = h.storage.SaveAPIToken(userID, token)
+	}
+	if err != nil {
+		log.Printf("❌ Failed to create API token: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to create token. Please try again.")
+	}
+
+	log.Printf("🔑 Minted API token for user %d", userID)
+	return h.reply(ctx, msg, fmt.Sprintf(
+		"🔑 *New API token:*\n`%s`\n\n"+
+			"Use it as `Authorization: Bearer <token>` with %s/api/v1/files\n"+
+			"It won't be shown again. Send /token revoke to revoke all your tokens.",
+		token, h.baseURL,
+	))
+}
+
+// cmdUsage shows the user's links, linked bytes and downloads this month against their quotas
+func (h *Handler) cmdUsage(ctx context.Context, msg *tg.Message) error {
+	userID := senderID(msg)
+	if userID == 0 {
+		return nil
+	}
+
+	usage, err := h.storage.GetUsage(userID)
+	if err != nil {
+		log.Printf("❌ Failed to load usage: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to load your usage. Please try again.")
+	}
+
+	limit := func(used, quota int64, format func(int64) string) string {
+		if quota <= 0 {
+			return format(used) + " (unlimited)"
+		}
+		return fmt.Sprintf("%s of %s", format(used), format(quota))
+	}
+	count := func(n int64) string { return fmt.Sprint(n) }
+
+	text := fmt.Sprintf("📊 *Your usage*\n\n"+
+		"🔗 Links: %s\n"+
+		"💾 Linked files: %s\n"+
+		"📤 Downloads this month: %s",
+		limit(usage.Links, h.quotas.MaxLinks, count),
+		limit(usage.LinkedBytes, h.quotas.MaxLinkedBytes, FormatFileSize),
+		limit(usage.EgressBytes, h.quotas.MonthlyEgress, FormatFileSize))
+	if h.quotas.EgressExceeded(usage) {
+		text += "\n\n🐢 Your monthly download allowance is used up, so downloads of your files are slowed down."
+	}
+	return h.reply(ctx, msg, text)
+}
@@ -0,0 +1,432 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	// diskVerifyTimeout bounds the hash lookup and write of one chunk
+	diskVerifyTimeout = 30 * time.Second
+
+	// diskLockStripes is the number of locks the cached files are spread over
+	diskLockStripes = 64
+)
+
+// DiskCacheStats is a snapshot of the disk cache
+type DiskCacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Stored    int64 `json:"stored"`
+	Rejected  int64 `json:"rejected"` // Chunks that failed or couldn't get hash verification
+	Evictions int64 `json:"evictions"`
+	Files     int   `json:"files"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+}
+
+// DiskCache keeps verified chunks of popular files on disk. Each file is a sparse data file
+// written at the chunks' offsets, plus an index with a bitmap of the chunks present.
+// A chunk is synced to disk before the index that marks it is atomically replaced, so a
+// crash can only lose chunks, never serve unwritten ones. Whole files are evicted least
+// recently used first.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	// locks guard the files on disk, striped by file ID. One is held shared while reading
+	// chunks and exclusively while writing or deleting files, so a file can't be evicted and
+	// recreated under a reader. Disk I/O only ever happens under these, never under mu.
+	locks [diskLockStripes]sync.RWMutex
+
+	// mu guards the fields below and the cached files' indexes. It's taken after a file lock, never before.
+	mu    sync.Mutex
+	files map[int64]*diskFile
+	bytes int64 // Cached bytes, plus the chunks being written
+	stats DiskCacheStats
+}
+
+// diskFile is the index of one cached file
+type diskFile struct {
+	FileID     int64     `json:"file_id"`
+	ChunkSize  int64     `json:"chunk_size"`
+	Present    []byte    `json:"present"` // Bit i is set when chunk i is stored
+	TailIndex  int64     `json:"tail_index"`
+	TailLength int64     `json:"tail_length"` // Length of the file's last chunk, once stored
+	Bytes      int64     `json:"bytes"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// NewDiskCache opens the cache directory, loading the indexes of files cached by earlier runs
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	c := &DiskCache{dir: dir, maxBytes: maxBytes, files: make(map[int64]*diskFile)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Index writes interrupted by a crash
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		idText, ok := strings.CutSuffix(name, ".idx")
+		if !ok {
+			continue
+		}
+		fileID, err := strconv.ParseInt(idText, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		f, err := c.loadIndex(fileID)
+		if err != nil {
+			log.Printf("⚠️ Dropping unreadable disk cache index %s: %v", name, err)
+			c.removeFiles(fileID)
+			continue
+		}
+		c.files[fileID] = f
+		c.bytes += f.Bytes
+	}
+
+	log.Printf("💾 Disk cache: %d file(s), %s of %s", len(c.files), FormatFileSize(c.bytes), FormatFileSize(maxBytes))
+	c.reserve(0, 0)
+	return c, nil
+}
+
+// fileLock returns the lock of a cached file's data and index
+func (c *DiskCache) fileLock(fileID int64) *sync.RWMutex {
+	return &c.locks[uint64(fileID)%diskLockStripes]
+}
+
+// read returns a cached chunk, or false if it isn't on disk
+func (c *DiskCache) read(key chunkKey) ([]byte, bool) {
+	lock := c.fileLock(key.fileID)
+	lock.RLock()
+
+	c.mu.Lock()
+	f, ok := c.files[key.fileID]
+	if !ok || f.ChunkSize != key.limit || !f.has(key.offset/key.limit) {
+		c.stats.Misses++
+		c.mu.Unlock()
+		lock.RUnlock()
+		return nil, false
+	}
+	length := key.limit
+	if index := key.offset / key.limit; index == f.TailIndex && f.TailLength > 0 {
+		length = f.TailLength
+	}
+	f.LastAccess = time.Now()
+	c.mu.Unlock()
+
+	data := make([]byte, length)
+	file, err := os.Open(c.dataPath(key.fileID))
+	if err == nil {
+		_, err = file.ReadAt(data, key.offset)
+		file.Close()
+	}
+	lock.RUnlock()
+
+	if err != nil {
+		log.Printf("⚠️ Disk cache read of file %d failed, dropping it: %v", key.fileID, err)
+		lock.Lock()
+		c.mu.Lock()
+		c.stats.Misses++
+		dropped := c.forgetLocked(key.fileID, f)
+		c.mu.Unlock()
+		if dropped {
+			c.removeFiles(key.fileID)
+		}
+		lock.Unlock()
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.stats.Hits++
+	c.mu.Unlock()
+	return data, true
+}
+
+// storeAsync verifies a downloaded chunk against Telegram's hashes, unless the reader already
+// did, and writes it to disk in the background, so the download isn't held up
+func (c *DiskCache) storeAsync(api *tg.Client, location tg.InputFileLocationClass, key chunkKey, data []byte, verified bool) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), diskVerifyTimeout)
+		defer cancel()
+		if err := c.store(ctx, api, location, key, data, verified); err != nil {
+			log.Printf("⚠️ Not caching chunk %d of file %d on disk: %v", key.offset, key.fileID, err)
+		}
+	}()
+}
+
+// store verifies and writes a chunk. Only the file's own lock is held while it's written and synced,
+// so reads and writes of other files carry on.
+func (c *DiskCache) store(ctx context.Context, api *tg.Client, location tg.InputFileLocationClass, key chunkKey, data []byte, verified bool) error {
+	if len(data) == 0 || int64(len(data)) > c.maxBytes {
+		return nil
+	}
+	size := int64(len(data))
+	index := key.offset / key.limit
+
+	c.mu.Lock()
+	if f, ok := c.files[key.fileID]; ok && f.ChunkSize == key.limit && f.has(index) {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	tail := size < key.limit
+	if !verified {
+		hashes, err := fileHashes(ctx, api, location, key.offset, key.offset+size)
+		if err == nil {
+			err = verifyChunk(data, key.offset, tail, hashes)
+		}
+		if err != nil {
+			c.mu.Lock()
+			c.stats.Rejected++
+			c.mu.Unlock()
+			return err
+		}
+	}
+
+	// Make room first, keeping this file. A file alone larger than the cap stays partly cached.
+	if !c.reserve(size, key.fileID) {
+		return nil
+	}
+	stored := false
+	defer func() {
+		if !stored {
+			c.mu.Lock()
+			c.bytes -= size
+			c.mu.Unlock()
+		}
+	}()
+
+	lock := c.fileLock(key.fileID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.Lock()
+	f, ok := c.files[key.fileID]
+	if ok && f.ChunkSize != key.limit {
+		// Cached with a different CHUNK_SIZE - start over
+		c.forgetLocked(key.fileID, f)
+		ok = false
+	}
+	if ok && f.has(index) {
+		c.mu.Unlock()
+		return nil
+	}
+	// The index is written from a copy, the cached one changes only once it's on disk
+	var next diskFile
+	if ok {
+		next = *f
+		next.Present = append([]byte(nil), f.Present...)
+	} else {
+		next = diskFile{FileID: key.fileID, ChunkSize: key.limit, TailIndex: -1}
+	}
+	c.mu.Unlock()
+
+	// Data first, synced, then the index that marks it present. A new file starts from an
+	// empty data file, and without the index an evicted one may have left behind.
+	flags := os.O_CREATE | os.O_WRONLY
+	if !ok {
+		os.Remove(c.indexPath(key.fileID))
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(c.dataPath(key.fileID), flags, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, key.offset)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	next.set(index)
+	if tail {
+		next.TailIndex = index
+		next.TailLength = size
+	}
+	next.Bytes += size
+	next.LastAccess = time.Now()
+	if err := c.writeIndex(&next); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current, cached := c.files[key.fileID]; ok && (!cached || current != f) {
+		// Evicted while it was written; the eviction deletes it once this returns
+		return nil
+	}
+	c.files[key.fileID] = &next
+	c.stats.Stored++
+	stored = true
+	return nil
+}
+
+// reserve evicts least recently used files until size more bytes fit, never evicting keep, and
+// counts the bytes as cached. It reports whether they fit.
+func (c *DiskCache) reserve(size int64, keep int64) bool {
+	c.mu.Lock()
+	var evicted []int64
+	fits := true
+	for c.bytes+size > c.maxBytes {
+		var oldest *diskFile
+		for id, f := range c.files {
+			if id == keep {
+				continue
+			}
+			if oldest == nil || f.LastAccess.Before(oldest.LastAccess) {
+				oldest = f
+			}
+		}
+		if oldest == nil {
+			fits = false
+			break
+		}
+		c.forgetLocked(oldest.FileID, oldest)
+		c.stats.Evictions++
+		evicted = append(evicted, oldest.FileID)
+	}
+	if fits {
+		c.bytes += size
+	}
+	c.mu.Unlock()
+
+	for _, fileID := range evicted {
+		c.deleteEvicted(fileID)
+	}
+	return fits
+}
+
+// deleteEvicted deletes an evicted file once nobody reads or writes it, unless it was cached anew since
+func (c *DiskCache) deleteEvicted(fileID int64) {
+	lock := c.fileLock(fileID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.Lock()
+	_, cached := c.files[fileID]
+	c.mu.Unlock()
+	if !cached {
+		c.removeFiles(fileID)
+	}
+}
+
+// forgetLocked removes f from the cached files if it's still the index of fileID, and reports
+// whether it was. Its files stay on disk for the caller to delete. The caller holds mu.
+func (c *DiskCache) forgetLocked(fileID int64, f *diskFile) bool {
+	if current, ok := c.files[fileID]; !ok || current != f {
+		return false
+	}
+	c.bytes -= f.Bytes
+	delete(c.files, fileID)
+	return true
+}
+
+// removeFiles deletes a file's index before its data, so a crash in between leaves no index
+// pointing at missing data
+func (c *DiskCache) removeFiles(fileID int64) {
+	os.Remove(c.indexPath(fileID))
+	os.Remove(c.dataPath(fileID))
+}
+
+// loadIndex reads a file's index from disk
+func (c *DiskCache) loadIndex(fileID int64) (*diskFile, error) {
+	data, err := os.ReadFile(c.indexPath(fileID))
+	if err != nil {
+		return nil, err
+	}
+	var f diskFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if f.FileID != fileID || f.ChunkSize <= 0 {
+		return nil, errors.New("index doesn't match its file")
+	}
+	return &f, nil
+}
+
+// writeIndex atomically replaces a file's index: written to a temporary file, synced, then renamed
+func (c *DiskCache) writeIndex(f *diskFile) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.indexPath(f.FileID) + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, c.indexPath(f.FileID))
+}
+
+// Stats returns a snapshot of the disk cache
+func (c *DiskCache) Stats() DiskCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Files = len(c.files)
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+func (c *DiskCache) dataPath(fileID int64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%d.data", fileID))
+}
+
+func (c *DiskCache) indexPath(fileID int64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%d.idx", fileID))
+}
+
+// has reports whether chunk i is stored
+func (f *diskFile) has(i int64) bool {
+	return i >= 0 && i/8 < int64(len(f.Present)) && f.Present[i/8]&(1<<(i%8)) != 0
+}
+
+// set marks chunk i as stored
+func (f *diskFile) set(i int64) {
+	for int64(len(f.Present)) <= i/8 {
+		f.Present = append(f.Present, 0)
+	}
+	f.Present[i/8] |= 1 << (i % 8)
+}
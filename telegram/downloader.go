@@ -2,11 +2,17 @@ package telegram
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"sync"
 
 	"github.com/gotd/td/tg"
+
+	"tele-bot/storage"
 )
 
 const (
@@ -14,13 +20,45 @@ const (
 	// Using 1MB (1024KB) to match TG-FileStreamBot reference implementation
 	// for maximum throughput by minimizing API round-trips.
 	ChunkSize = 1024 * 1024
+
+	// minChunkSize is the smallest chunk Telegram serves
+	minChunkSize = 4 * 1024
+
+	// verifyAttempts is how many times a chunk failing verification is downloaded
+	verifyAttempts = 3
 )
 
+var (
+	// chunkSize is the size of the chunks downloads are requested in, at most ChunkSize
+	chunkSize int64 = ChunkSize
+
+	// downloadConcurrency is the number of chunks of one download fetched in parallel
+	downloadConcurrency = 1
+)
+
+// ConfigureDownloads sets the chunk size and how many chunks of one download are fetched in parallel.
+// Telegram requires chunks to evenly divide 1 MiB, so the size must be a power of two from 4 KiB to 1 MiB.
+// Call it once at startup, before any download.
+func ConfigureDownloads(size int64, concurrency int) error {
+	if size < minChunkSize || size > ChunkSize || size&(size-1) != 0 {
+		return fmt.Errorf("chunk size must be a power of two between %d and %d bytes, got %d", minChunkSize, ChunkSize, size)
+	}
+	if concurrency < 1 {
+		return fmt.Errorf("download concurrency must be at least 1, got %d", concurrency)
+	}
+	chunkSize = size
+	downloadConcurrency = concurrency
+	return nil
+}
+
 // TelegramReader implements io.ReadCloser for streaming Telegram file downloads
 type TelegramReader struct {
 	ctx           context.Context
+	cancel        context.CancelFunc // Stops chunks still being prefetched
 	api           *tg.Client
+	mu            sync.Mutex // Guards location, generation and refresh, shared by prefetching chunks
 	location      tg.InputFileLocationClass
+	generation    int   // Bumped on every file reference refresh
 	start         int64 // Requested start byte
 	end           int64 // Requested end byte (inclusive)
 	next          func() ([]byte, error)
@@ -28,8 +66,22 @@ type TelegramReader struct {
 	bufferPos     int64
 	bytesRead     int64
 	contentLength int64
+	refresh       ReferenceRefresher // Called once if the file reference has expired
+	workers       *Workers           // Spreads chunk requests over several bots, nil to use api only
+	file          *storage.FileMetadata
+	scheduler     *Scheduler // Global cap on chunk requests in flight, nil for none
+	flow          Flow
+	cache         *ChunkCache // Chunks shared between readers, nil to always download
+	disk          *DiskCache  // Verified chunks kept across restarts, nil for none
+	verify        bool        // Check every downloaded chunk against Telegram's file hashes
+	digest        hash.Hash   // SHA-256 of the bytes read so far, nil unless onDigest is set
+	onDigest      func(sum []byte)
+	fileID        int64
 }
 
+// ReferenceRefresher fetches a fresh file_reference for a file whose reference expired
+type ReferenceRefresher func(ctx context.Context) ([]byte, error)
+
 // NewTelegramReader creates a new reader for downloading a byte range from Telegram
 func NewTelegramReader(
 	ctx context.Context,
@@ -39,7 +91,7 @@ func NewTelegramReader(
 	fileReference []byte,
 	start int64,
 	end int64,
-) io.ReadCloser {
+) *TelegramReader {
 	contentLength := end - start + 1
 
 	location := &tg.InputDocumentFileLocation{
@@ -49,13 +101,16 @@ func NewTelegramReader(
 		ThumbSize:     "",
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
 	r := &TelegramReader{
 		ctx:           ctx,
+		cancel:        cancel,
 		api:           api,
 		location:      location,
 		start:         start,
 		end:           end,
 		contentLength: contentLength,
+		fileID:        fileID,
 	}
 
 	log.Printf("📥 TelegramReader: start=%d, end=%d, contentLength=%d", start, end, contentLength)
@@ -64,8 +119,57 @@ func NewTelegramReader(
 	return r
 }
 
+// OnReferenceExpired sets how to get a new file reference when Telegram reports the current one as expired
+func (r *TelegramReader) OnReferenceExpired(refresh ReferenceRefresher) {
+	r.refresh = refresh
+}
+
+// UseWorkers spreads the reader's chunk requests over a pool of bots.
+// Chunks fall back to the reader's own API when a helper bot fails.
+func (r *TelegramReader) UseWorkers(workers *Workers, file *storage.FileMetadata) {
+	r.workers = workers
+	r.file = file
+}
+
+// Schedule makes every chunk request wait its turn in a scheduler shared by all downloads.
+// Small ranges and the first chunks of any range are interactive and go first.
+func (r *TelegramReader) Schedule(scheduler *Scheduler, client, link string) {
+	r.scheduler = scheduler
+	r.flow = Flow{
+		Client:      client,
+		Link:        link,
+		Interactive: r.contentLength <= InteractiveRangeSize,
+	}
+}
+
+// UseCache serves chunks from a cache shared by all readers, downloading only on a miss
+func (r *TelegramReader) UseCache(cache *ChunkCache) {
+	r.cache = cache
+}
+
+// UseDiskCache serves chunks from disk when present and stores verified downloads there
+func (r *TelegramReader) UseDiskCache(disk *DiskCache) {
+	r.disk = disk
+}
+
+// Verify checks every chunk downloaded from Telegram against the file's SHA-256 block hashes,
+// downloading it again when it doesn't match
+func (r *TelegramReader) Verify() {
+	r.verify = true
+}
+
+// OnDigest calls done with the SHA-256 of everything read once the whole range has been read.
+// Combined with Verify on a range covering the whole file, that's a trustworthy digest of the file.
+func (r *TelegramReader) OnDigest(done func(sum []byte)) {
+	r.digest = sha256.New()
+	r.onDigest = done
+}
+
 // Close implements io.Closer
 func (r *TelegramReader) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
 	return nil
 }
 
@@ -98,22 +202,196 @@ func (r *TelegramReader) Read(p []byte) (n int, err error) {
 	r.bufferPos += int64(n)
 	r.bytesRead += int64(n)
 
+	if r.digest != nil {
+		r.digest.Write(p[:n])
+		if r.bytesRead == r.contentLength {
+			r.onDigest(r.digest.Sum(nil))
+		}
+	}
+
 	return n, nil
 }
 
-// chunk fetches a single chunk from Telegram at the given offset
-func (r *TelegramReader) chunk(offset int64, limit int64) ([]byte, error) {
+// chunk returns the chunk at the given offset, from the caches if possible.
+// Interactive chunks are ones a viewer is waiting on, scheduled ahead of bulk transfers.
+func (r *TelegramReader) chunk(offset int64, limit int64, interactive bool) ([]byte, error) {
+	if r.cache == nil {
+		return r.loadChunk(offset, limit, interactive)
+	}
+	key := chunkKey{fileID: r.fileID, offset: offset, limit: limit}
+	return r.cache.get(r.ctx, key, func() ([]byte, error) {
+		return r.loadChunk(offset, limit, interactive)
+	})
+}
+
+// loadChunk reads a chunk from the disk cache, or downloads it and stores it there
+func (r *TelegramReader) loadChunk(offset int64, limit int64, interactive bool) ([]byte, error) {
+	if r.disk == nil {
+		return r.downloadChunk(offset, limit, interactive)
+	}
+
+	key := chunkKey{fileID: r.fileID, offset: offset, limit: limit}
+	if data, ok := r.disk.read(key); ok {
+		return data, nil
+	}
+
+	data, err := r.downloadChunk(offset, limit, interactive)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	location := r.location
+	r.mu.Unlock()
+	r.disk.storeAsync(r.api, location, key, data, r.verify)
+	return data, nil
+}
+
+// downloadChunk fetches a chunk from Telegram, verifying it when verification is on
+func (r *TelegramReader) downloadChunk(offset int64, limit int64, interactive bool) ([]byte, error) {
+	if !r.verify {
+		return r.fetchChunk(offset, limit, interactive)
+	}
+
+	hashes, err := r.chunkHashes(offset, offset+limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		data, err := r.fetchChunk(offset, limit, interactive)
+		if err != nil {
+			return nil, err
+		}
+		err = verifyChunk(data, offset, int64(len(data)) < limit, hashes)
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, ErrHashMismatch) || attempt == verifyAttempts {
+			return nil, fmt.Errorf("failed to verify chunk at offset %d: %w", offset, err)
+		}
+		log.Printf("⚠️ Chunk at offset %d of file %d failed verification, downloading it again: %v", offset, r.fileID, err)
+	}
+}
+
+// chunkHashes fetches Telegram's block hashes covering [offset, end), refreshing an expired file reference once
+func (r *TelegramReader) chunkHashes(offset, end int64) ([]tg.FileHash, error) {
+	r.mu.Lock()
+	location, generation := r.location, r.generation
+	r.mu.Unlock()
+
+	hashes, err := fileHashes(r.ctx, r.api, location, offset, end)
+	if err != nil && isFileReferenceError(err) {
+		location, refreshErr := r.refreshLocation(offset, generation)
+		if refreshErr != nil {
+			return nil, fmt.Errorf("failed to refresh file reference: %w", refreshErr)
+		}
+		if location != nil {
+			hashes, err = fileHashes(r.ctx, r.api, location, offset, end)
+		}
+	}
+	return hashes, err
+}
+
+// fetchChunk fetches a single chunk from Telegram at the given offset, through the least busy worker
+func (r *TelegramReader) fetchChunk(offset int64, limit int64, interactive bool) ([]byte, error) {
+	if r.scheduler != nil {
+		flow := r.flow
+		if interactive {
+			flow.Interactive = true
+		}
+		release, err := r.scheduler.Acquire(r.ctx, flow)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	if r.workers == nil {
+		return r.directChunk(offset, limit)
+	}
+
+	worker := r.workers.pick(r.file)
+	if worker != r.workers.primary {
+		done := worker.begin()
+		data, err := worker.chunk(r.ctx, r.file, offset, limit)
+		done(err)
+		if err == nil {
+			return data, nil
+		}
+		log.Printf("⚠️ Worker %s failed at offset %d, using the main bot: %v", worker.Name, offset, err)
+	}
+
+	done := r.workers.primary.begin()
+	data, err := r.directChunk(offset, limit)
+	done(err)
+	return data, err
+}
+
+// directChunk fetches a chunk through the reader's own API, refreshing an expired file reference once
+func (r *TelegramReader) directChunk(offset int64, limit int64) ([]byte, error) {
+	r.mu.Lock()
+	location, generation := r.location, r.generation
+	r.mu.Unlock()
+
 	req := &tg.UploadGetFileRequest{
-		Location: r.location,
+		Location: location,
 		Offset:   offset,
 		Limit:    int(limit),
 	}
 
-	res, err := r.api.UploadGetFile(r.ctx, req)
+	res, err := uploadGetFile(r.ctx, r.api, req)
+	if err != nil && isFileReferenceError(err) {
+		location, refreshErr := r.refreshLocation(offset, generation)
+		if refreshErr != nil {
+			return nil, fmt.Errorf("failed to refresh file reference: %w", refreshErr)
+		}
+		if location != nil {
+			req.Location = location
+			res, err = uploadGetFile(r.ctx, r.api, req)
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chunk at offset %d: %w", offset, err)
 	}
+	return fileBytes(res)
+}
+
+// refreshLocation replaces the location's expired file reference and returns the new location.
+// Chunks fetched in parallel share one refresh: if another chunk refreshed since generation,
+// its location is returned. It returns nil when the reference can't be refreshed (again).
+func (r *TelegramReader) refreshLocation(offset int64, generation int) (tg.InputFileLocationClass, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.generation != generation {
+		return r.location, nil
+	}
+
+	// Refresh only once per reader, a second failure won't be fixed by another refresh
+	refresh := r.refresh
+	r.refresh = nil
+	if refresh == nil {
+		return nil, nil
+	}
 
+	log.Printf("🔄 File reference expired at offset %d, refreshing", offset)
+	fileReference, err := refresh(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks in flight hold the old location, so replace it rather than modify it
+	if location, ok := r.location.(*tg.InputDocumentFileLocation); ok {
+		refreshed := *location
+		refreshed.FileReference = fileReference
+		r.location = &refreshed
+	}
+	r.generation++
+	return r.location, nil
+}
+
+// fileBytes returns the data of an upload.getFile response
+func fileBytes(res tg.UploadFileClass) ([]byte, error) {
 	switch result := res.(type) {
 	case *tg.UploadFile:
 		return result.Bytes, nil
@@ -124,35 +402,62 @@ func (r *TelegramReader) chunk(offset int64, limit int64) ([]byte, error) {
 	}
 }
 
-// partStream returns a closure that fetches and trims chunks sequentially
+// partStream returns a closure that returns trimmed chunks in order.
+// Up to downloadConcurrency chunks are fetched ahead in parallel.
 func (r *TelegramReader) partStream() func() ([]byte, error) {
 	start := r.start
 	end := r.end
+	size := chunkSize
+	concurrency := downloadConcurrency
 
 	// Align offset to chunk boundary (round down)
-	offset := start - (start % ChunkSize)
+	offset := start - (start % size)
 
 	// Calculate trimming for first and last chunks
 	firstPartCut := start - offset
-	lastPartCut := (end % ChunkSize) + 1
-	partCount := int((end - offset + ChunkSize) / ChunkSize)
+	lastPartCut := (end % size) + 1
+	partCount := int((end - offset + size) / size)
 	currentPart := 1
 
-	log.Printf("📊 partStream: offset=%d, firstCut=%d, lastCut=%d, parts=%d",
-		offset, firstPartCut, lastPartCut, partCount)
+	log.Printf("📊 partStream: offset=%d, firstCut=%d, lastCut=%d, parts=%d, chunk=%d, concurrency=%d",
+		offset, firstPartCut, lastPartCut, partCount, size, concurrency)
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	var pending []chan result
+	scheduled := 0
+
+	// Keep up to concurrency chunk requests in flight
+	fill := func() {
+		for len(pending) < concurrency && scheduled < partCount {
+			done := make(chan result, 1)
+			chunkOffset := offset + int64(scheduled)*size
+			interactive := scheduled < interactiveChunks
+			go func() {
+				data, err := r.chunk(chunkOffset, size, interactive)
+				done <- result{data, err}
+			}()
+			pending = append(pending, done)
+			scheduled++
+		}
+	}
 
-	// Return a closure that fetches one chunk per call
+	// Return a closure that returns one chunk per call
 	return func() ([]byte, error) {
 		// Done fetching all parts?
 		if currentPart > partCount {
 			return []byte{}, nil
 		}
 
-		// Fetch chunk from Telegram
-		chunk, err := r.chunk(offset, ChunkSize)
-		if err != nil {
-			return nil, err
+		fill()
+		res := <-pending[0]
+		pending = pending[1:]
+		if res.err != nil {
+			return nil, res.err
 		}
+		chunk := res.data
 
 		// Empty chunk = EOF
 		if len(chunk) == 0 {
@@ -173,8 +478,43 @@ func (r *TelegramReader) partStream() func() ([]byte, error) {
 		// Middle chunks: no trimming needed
 
 		currentPart++
-		offset += ChunkSize
 
 		return chunk, nil
 	}
 }
+
+// DownloadThumb downloads a document thumbnail of the given PhotoSize type in full
+func DownloadThumb(
+	ctx context.Context,
+	api *tg.Client,
+	fileID int64,
+	accessHash int64,
+	fileReference []byte,
+	thumbType string,
+	refresh ReferenceRefresher,
+) ([]byte, error) {
+	r := &TelegramReader{
+		ctx:     ctx,
+		api:     api,
+		refresh: refresh,
+		location: &tg.InputDocumentFileLocation{
+			ID:            fileID,
+			AccessHash:    accessHash,
+			FileReference: fileReference,
+			ThumbSize:     thumbType,
+		},
+	}
+
+	// Thumbnails are tiny, but keep requesting until a short chunk marks the end
+	var data []byte
+	for offset := int64(0); ; offset += ChunkSize {
+		chunk, err := r.directChunk(offset, ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		if len(chunk) < ChunkSize {
+			return data, nil
+		}
+	}
+}
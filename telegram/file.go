@@ -0,0 +1,192 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/gotd/td/tg"
+)
+
+// File gives random access to a Telegram document. Reads are split into aligned chunks
+// fetched through the embedded TelegramReader, so its setters decide which bots, scheduler,
+// caches and verification the chunks go through.
+//
+// ReadAt may be called concurrently. Read and Seek share a position and, like any
+// io.ReadSeeker, must not be.
+type File struct {
+	*TelegramReader
+	size      int64
+	chunkSize int64
+
+	// Sequential reading state
+	pos          int64
+	seekedTo     int64                      // Aligned offset of the last seek, the chunks after it are interactive
+	current      []byte                     // Chunk at currentAt, reused by small reads
+	currentAt    int64                      // -1 when current is empty
+	ahead        map[int64]chan chunkResult // Chunks prefetched by Read
+	digestedUpTo int64                      // Bytes fed to the digest, which needs them in order from 0
+}
+
+// chunkResult is a chunk downloaded in the background
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// NewFile opens a Telegram document of the given size for random access
+func NewFile(
+	ctx context.Context,
+	api *tg.Client,
+	fileID int64,
+	accessHash int64,
+	fileReference []byte,
+	size int64,
+) *File {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &TelegramReader{
+		ctx:    ctx,
+		cancel: cancel,
+		api:    api,
+		location: &tg.InputDocumentFileLocation{
+			ID:            fileID,
+			AccessHash:    accessHash,
+			FileReference: fileReference,
+		},
+		end:           size - 1,
+		contentLength: size,
+		fileID:        fileID,
+	}
+
+	return &File{
+		TelegramReader: r,
+		size:           size,
+		chunkSize:      chunkSize,
+		currentAt:      -1,
+		ahead:          make(map[int64]chan chunkResult),
+	}
+}
+
+// Size returns the size of the file
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// ReadAt implements io.ReaderAt
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("telegram: negative offset")
+	}
+
+	aligned := off - off%f.chunkSize
+	n := 0
+	for n < len(p) && off < f.size {
+		chunkOffset := off - off%f.chunkSize
+		// A reader waits on the first chunks of every read, and on all of a small one
+		interactive := len(p) <= InteractiveRangeSize || chunkOffset < aligned+interactiveChunks*f.chunkSize
+
+		data, err := f.chunk(chunkOffset, f.chunkSize, interactive)
+		if err != nil {
+			return n, err
+		}
+		if off-chunkOffset >= int64(len(data)) {
+			// Telegram's file is shorter than the size we were given
+			break
+		}
+		copied := copy(p[n:], data[off-chunkOffset:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker. It only moves the position, nothing is fetched until the next Read.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("telegram: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("telegram: negative position")
+	}
+
+	if offset != f.pos {
+		f.pos = offset
+		f.seekedTo = offset - offset%f.chunkSize
+		// Prefetched chunks may be far from the new position; the caches still keep them
+		clear(f.ahead)
+	}
+	return offset, nil
+}
+
+// Read implements io.Reader, fetching up to downloadConcurrency chunks ahead of the position
+func (f *File) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if f.ctx.Err() != nil {
+		return 0, f.ctx.Err()
+	}
+
+	chunkOffset := f.pos - f.pos%f.chunkSize
+	if chunkOffset != f.currentAt {
+		data, err := f.sequentialChunk(chunkOffset)
+		if err != nil {
+			return 0, err
+		}
+		f.current, f.currentAt = data, chunkOffset
+	}
+
+	start := f.pos - chunkOffset
+	if start >= int64(len(f.current)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.current[start:])
+
+	if f.digest != nil && f.pos == f.digestedUpTo {
+		f.digest.Write(p[:n])
+		f.digestedUpTo += int64(n)
+		if f.digestedUpTo == f.size {
+			f.onDigest(f.digest.Sum(nil))
+		}
+	}
+
+	f.pos += int64(n)
+	return n, nil
+}
+
+// sequentialChunk returns the chunk at offset, prefetched if possible, and starts fetching the ones after it
+func (f *File) sequentialChunk(offset int64) ([]byte, error) {
+	concurrency := int64(downloadConcurrency)
+	for next := offset; next < offset+concurrency*f.chunkSize && next < f.size; next += f.chunkSize {
+		if _, ok := f.ahead[next]; ok {
+			continue
+		}
+		done := make(chan chunkResult, 1)
+		interactive := next < f.seekedTo+interactiveChunks*f.chunkSize
+		go func() {
+			data, err := f.chunk(next, f.chunkSize, interactive)
+			done <- chunkResult{data, err}
+		}()
+		f.ahead[next] = done
+	}
+
+	done := f.ahead[offset]
+	delete(f.ahead, offset)
+
+	select {
+	case res := <-done:
+		return res.data, res.err
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+}
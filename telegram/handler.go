@@ -2,9 +2,12 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"mime"
+	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/gotd/td/telegram/message"
@@ -19,6 +22,32 @@ type Handler struct {
 	baseURL string
 	api     *tg.Client
 	sender  *message.Sender
+	mirror  *tg.InputPeerChannel // Channel incoming files are copied to, nil to disable
+	quotas  storage.Quotas
+
+	storageChannelID int64 // Channel HTTP uploads are posted to, which message links may never point at
+
+	// Channel indexing mode
+	indexing ChannelIndexing
+	watched  map[int64]bool
+
+	// User-account mode
+	userMode     bool
+	self         int64
+	allowedChats map[int64]bool
+
+	// Access control
+	access AccessControl
+	admins map[int64]bool
+	policy storage.AccessPolicy // Rules from the config, which stored rules take precedence over
+
+	serviceStats func() ServiceStats // Live HTTP server figures for /stats, nil if unknown
+
+	// Access hashes learned from updates, keyed by user and channel ID
+	userHashes    sync.Map
+	channelHashes sync.Map
+
+	ctx context.Context // Lives as long as Register, for work that outlasts a single update
 }
 
 // NewHandler creates a new message handler
@@ -31,9 +60,27 @@ func NewHandler(api *tg.Client, storage *storage.Storage, baseURL string) *Handl
 	}
 }
 
+// SetMirrorChannel makes the handler copy every incoming file to a private channel and use that copy
+// as the file's source, so links keep working after users delete their chat with the bot
+func (h *Handler) SetMirrorChannel(channel *tg.InputPeerChannel) {
+	h.mirror = channel
+}
+
+// SetStorageChannel tells the handler which channel HTTP uploads are posted to, so pasted
+// message links can't be used to link other users' uploads
+func (h *Handler) SetStorageChannel(channel *tg.InputPeerChannel) {
+	h.storageChannelID = channel.ChannelID
+}
+
+// SetQuotas limits the links each user can create. Users over a quota get an explanation instead of a link.
+func (h *Handler) SetQuotas(quotas storage.Quotas) {
+	h.quotas = quotas
+}
+
 // Start registers message handlers with the pre-created dispatcher
 func (h *Handler) Register(ctx context.Context, dispatcher *tg.UpdateDispatcher) error {
 	log.Println("📡 Registering message handlers...")
+	h.ctx = ctx
 
 	// Register handler with the EXISTING dispatcher (wired to client)
 	dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
@@ -43,32 +90,22 @@ func (h *Handler) Register(ctx context.Context, dispatcher *tg.UpdateDispatcher)
 			log.Println("⚠️  Message is not *tg.Message type")
 			return nil
 		}
-
-		log.Printf("📩 Received message from user %d, text: %s", msg.PeerID, msg.Message)
-
-		// Check for /start command
-		if msg.Message == "/start" {
-			log.Println("🚀 Received /start command")
-			peer := h.getPeerFromMessage(msg)
-			if peer != nil {
-				_, err := h.sender.To(peer).Text(ctx,
-					"🎉 *Welcome to File Link Generator Bot!*\n\n"+
-						"Send me any file and I'll generate a download link for you.\n\n"+
-						"Features:\n"+
-						"📁 Documents, PDFs\n"+
-						"🖼 Photos\n"+
-						"🔗 HTTP Range support for resumable downloads")
-				if err != nil {
-					log.Printf("❌ Failed to send /start response: %v", err)
-				} else {
-					log.Println("✅ Sent /start welcome message")
-				}
-				return err
-			}
-		}
-
-		return h.ProcessMessage(ctx, msg, e)
+		return h.onMessage(ctx, e, msg)
 	})
+
+	// Posts in watched channels arrive as channel updates
+	dispatcher.OnNewChannelMessage(h.onChannelMessage)
+	// Inline buttons: Stats on upload replies, approve/deny on access requests
+	dispatcher.OnBotCallbackQuery(h.onCallbackQuery)
+	if len(h.watched) > 0 {
+		log.Printf("📢 Indexing %d channel(s)", len(h.watched))
+	}
+	if h.userMode {
+		log.Printf("👤 User mode: handling Saved Messages and %d allowed chat(s)", len(h.allowedChats))
+	}
+	if h.access.Mode == AccessApproval {
+		log.Printf("🔐 Access by approval of %d admin(s)", len(h.admins))
+	}
 	log.Println("✅ Handlers registered - bot is now listening!")
 
 	// Wait for context cancellation - the client handles updates automatically now
@@ -76,6 +113,76 @@ func (h *Handler) Register(ctx context.Context, dispatcher *tg.UpdateDispatcher)
 	return ctx.Err()
 }
 
+// onMessage routes a new message to a command, a pasted link or ProcessMessage
+func (h *Handler) onMessage(ctx context.Context, e tg.Entities, msg *tg.Message) error {
+	if h.userMode && !h.allowedInUserMode(msg) {
+		return nil
+	}
+	h.rememberPeers(e)
+	messagesHandled.WithLabelValues(messageType(msg)).Inc()
+
+	// A user account only serves its owner and chats they chose, the bot checks the access rules
+	if !h.userMode {
+		switch h.checkAccess(msg, e) {
+		case storage.AccessDenied:
+			log.Printf("🚫 Ignoring message from denied user %d", senderID(msg))
+			return nil
+		case storage.AccessUnknown:
+			return h.requestAccess(ctx, msg, e)
+		}
+	}
+
+	log.Printf("📩 Received message from user %d, text: %s", msg.PeerID, msg.Message)
+
+	// Bot commands; unknown ones fall through to the usual instructions reply
+	if msg.Media == nil && strings.HasPrefix(msg.Message, "/") {
+		if handled, err := h.handleCommand(ctx, msg, e); handled {
+			return err
+		}
+	}
+
+	// Pasted t.me links to files in channels
+	if msg.Media == nil {
+		if link, ok := ParseMessageLink(msg.Message); ok {
+			return h.ProcessMessageLink(ctx, msg, link)
+		}
+	}
+
+	// A user account sees its own replies as new messages, so other text is left alone
+	if h.userMode && msg.Media == nil {
+		return nil
+	}
+
+	return h.ProcessMessage(ctx, msg, e)
+}
+
+// onCallbackQuery routes a press of an inline button by the prefix of its data, then answers it
+// with the text the button's handler returns, shown to the user as a toast
+func (h *Handler) onCallbackQuery(ctx context.Context, e tg.Entities, q *tg.UpdateBotCallbackQuery) error {
+	h.rememberPeers(e)
+	messagesHandled.WithLabelValues("callback").Inc()
+
+	var answer string
+	var err error
+	kind, data, _ := strings.Cut(string(q.Data), ":")
+	switch kind {
+	case "stats":
+		answer, err = h.onStatsButton(ctx, q, data)
+	case "access":
+		answer, err = h.onAccessButton(ctx, q, data)
+	}
+	if err != nil {
+		log.Printf("❌ Failed to handle %s button: %v", kind, err)
+		answer = "❌ Failed, please try again."
+	}
+
+	_, err = h.api.MessagesSetBotCallbackAnswer(ctx, &tg.MessagesSetBotCallbackAnswerRequest{
+		QueryID: q.QueryID,
+		Message: answer,
+	})
+	return err
+}
+
 // ProcessMessage handles incoming messages with file uploads
 func (h *Handler) ProcessMessage(ctx context.Context, msg *tg.Message, entities tg.Entities) error {
 	// Check if message contains media
@@ -101,64 +208,8 @@ func (h *Handler) ProcessMessage(ctx context.Context, msg *tg.Message, entities
 	}
 
 	// Process different media types
-	var fileID int64
-	var accessHash int64
-	var fileReference []byte
-	var fileName string
-	var fileSize int64
-	var mimeType string
-
-	switch media := msg.Media.(type) {
-	case *tg.MessageMediaDocument:
-		doc, ok := media.Document.(*tg.Document)
-		if !ok {
-			return nil
-		}
-
-		fileID = doc.ID
-		accessHash = doc.AccessHash
-		fileReference = doc.FileReference
-		fileSize = doc.Size
-		mimeType = doc.MimeType
-
-		doc.AsInputDocumentFileLocation()
-
-		// Extract filename from attributes
-		for _, attr := range doc.Attributes {
-			if filenameAttr, ok := attr.(*tg.DocumentAttributeFilename); ok {
-				fileName = filenameAttr.FileName
-				break
-			}
-		}
-
-		if fileName == "" {
-			// Generate filename from extension
-			exts, _ := mime.ExtensionsByType(mimeType)
-			ext := ".bin"
-			if len(exts) > 0 {
-				ext = exts[0]
-			}
-			fileName = fmt.Sprintf("file_%d%s", fileID, ext)
-		}
-
-	case *tg.MessageMediaPhoto:
-		// Handle photos
-		photo, ok := media.Photo.(*tg.Photo)
-		if !ok {
-			return nil
-		}
-
-		fileID = photo.ID
-		accessHash = photo.AccessHash
-		fileReference = photo.FileReference
-		fileSize = 0 // Photos don't have a single size
-		fileName = fmt.Sprintf("photo_%d.jpg", photo.ID)
-		mimeType = "image/jpeg"
-
-		// For photos, we'd need to find the largest size
-		// Simplified for now
-
-	default:
+	meta, err := ExtractFileMetadata(msg.Media)
+	if errors.Is(err, ErrUnsupportedMedia) {
 		peer := h.getPeerFromMessage(msg)
 		if peer != nil {
 			_, err := h.sender.To(peer).Text(ctx,
@@ -167,12 +218,37 @@ func (h *Handler) ProcessMessage(ctx context.Context, msg *tg.Message, entities
 		}
 		return nil
 	}
+	if meta == nil {
+		return nil
+	}
 
 	// Generate unique link ID
-	linkID := uuid.New().String()
+	meta.LinkID = uuid.New().String()
+	meta.OwnerID = senderID(msg)
+
+	if refused, err := h.refuseOverQuota(ctx, msg, meta); refused {
+		return err
+	}
+
+	// Record where the file can be re-fetched from when its reference expires
+	h.setSource(ctx, msg, entities, meta)
+
+	return h.saveAndReply(ctx, msg, meta)
+}
+
+// saveAndReply files a new link under the owner's active collection, saves it and replies with the link
+func (h *Handler) saveAndReply(ctx context.Context, msg *tg.Message, meta *storage.FileMetadata) error {
+	// Tag the file with the uploader's active collection
+	if meta.OwnerID != 0 {
+		collection, err := h.storage.GetActiveCollection(meta.OwnerID)
+		if err != nil {
+			log.Printf("⚠️ Failed to load active collection: %v", err)
+		}
+		meta.Collection = collection
+	}
 
 	// Save metadata to database
-	err := h.storage.SaveFile(linkID, fileID, accessHash, fileReference, fileName, fileSize, mimeType)
+	err := h.storage.SaveFile(meta)
 	if err != nil {
 		log.Printf("❌ Failed to save file metadata: %v", err)
 		peer := h.getPeerFromMessage(msg)
@@ -185,23 +261,34 @@ func (h *Handler) ProcessMessage(ctx context.Context, msg *tg.Message, entities
 	}
 
 	// Generate download link
-	downloadLink := fmt.Sprintf("%s/download/%s", h.baseURL, linkID)
+	downloadLink := fmt.Sprintf("%s/download/%s", h.baseURL, meta.LinkID)
 
 	// Log the upload
-	log.Printf("✅ File uploaded: %s -> %s (Size: %s)", fileName, downloadLink, formatFileSize(fileSize))
+	log.Printf("✅ File uploaded: %s -> %s (Size: %s)", meta.FileName, downloadLink, FormatFileSize(meta.FileSize))
 
 	// Send reply with download link
 	peer := h.getPeerFromMessage(msg)
 	if peer != nil {
-		_, err = h.sender.To(peer).Text(ctx, fmt.Sprintf(
+		// User accounts can't attach inline buttons
+		builder := &h.sender.To(peer).Builder
+		if !h.userMode {
+			builder = builder.Markup(statsButton(meta.LinkID))
+		}
+		_, err = builder.Text(ctx, fmt.Sprintf(
 			"✅ *File uploaded successfully!*\n\n"+
 				"📁 Name: `%s`\n"+
-				"📊 Size: %s\n\n"+
+				"📊 Size: %s\n"+
+				"%s\n"+
+				"%s"+
 				"🔗 *Download link:*\n%s\n\n"+
+				"👁 *Preview:*\n%s/file/%s\n\n"+
 				"_Link valid for downloads_",
-			fileName,
-			formatFileSize(fileSize),
+			meta.FileName,
+			FormatFileSize(meta.FileSize),
+			mediaSummary(meta),
+			collectionSummary(meta),
 			downloadLink,
+			h.baseURL, meta.LinkID,
 		))
 
 		if err != nil {
@@ -212,15 +299,286 @@ func (h *Handler) ProcessMessage(ctx context.Context, msg *tg.Message, entities
 	return err
 }
 
+// ErrUnsupportedMedia is returned by ExtractFileMetadata for media that can't be linked
+var ErrUnsupportedMedia = errors.New("unsupported media type")
+
+// ExtractFileMetadata reads the file described by a message's media.
+// It returns nil without an error when the media holds no file, such as an empty document.
+func ExtractFileMetadata(media tg.MessageMediaClass) (*storage.FileMetadata, error) {
+	meta := &storage.FileMetadata{}
+
+	switch media := media.(type) {
+	case *tg.MessageMediaDocument:
+		doc, ok := media.Document.(*tg.Document)
+		if !ok {
+			return nil, nil
+		}
+
+		meta.FileID = doc.ID
+		meta.AccessHash = doc.AccessHash
+		meta.FileReference = doc.FileReference
+		meta.FileSize = doc.Size
+		meta.MimeType = doc.MimeType
+
+		meta.ThumbType, meta.ThumbSize, meta.ThumbStripped = documentThumb(doc)
+
+		// Extract filename and media info from attributes
+		applyDocumentAttributes(meta, doc.Attributes)
+
+		if meta.FileName == "" {
+			// Generate filename from extension
+			exts, _ := mime.ExtensionsByType(meta.MimeType)
+			ext := ".bin"
+			if len(exts) > 0 {
+				ext = exts[0]
+			}
+			meta.FileName = fmt.Sprintf("file_%d%s", meta.FileID, ext)
+		}
+
+	case *tg.MessageMediaPhoto:
+		// Handle photos
+		photo, ok := media.Photo.(*tg.Photo)
+		if !ok {
+			return nil, nil
+		}
+
+		meta.FileID = photo.ID
+		meta.AccessHash = photo.AccessHash
+		meta.FileReference = photo.FileReference
+		meta.FileSize = 0 // Photos don't have a single size
+		meta.FileName = fmt.Sprintf("photo_%d.jpg", photo.ID)
+		meta.MimeType = "image/jpeg"
+
+		// For photos, we'd need to find the largest size
+		// Simplified for now
+
+	default:
+		return nil, ErrUnsupportedMedia
+	}
+
+	return meta, nil
+}
+
+// documentThumb picks the largest downloadable thumbnail of a document.
+// The stripped thumbnail is returned alongside as a fallback that needs no download.
+func documentThumb(doc *tg.Document) (thumbType string, thumbSize int64, stripped []byte) {
+	var bestArea int
+	for _, size := range doc.Thumbs {
+		switch t := size.(type) {
+		case *tg.PhotoSize:
+			if area := t.W * t.H; area > bestArea {
+				bestArea = area
+				thumbType = t.Type
+				thumbSize = int64(t.Size)
+			}
+		case *tg.PhotoSizeProgressive:
+			if area := t.W * t.H; area > bestArea && len(t.Sizes) > 0 {
+				bestArea = area
+				thumbType = t.Type
+				thumbSize = int64(t.Sizes[len(t.Sizes)-1])
+			}
+		case *tg.PhotoStrippedSize:
+			stripped = t.Bytes
+		}
+	}
+	return thumbType, thumbSize, stripped
+}
+
+// applyDocumentAttributes copies the filename and media attributes of a document into meta
+func applyDocumentAttributes(meta *storage.FileMetadata, attrs []tg.DocumentAttributeClass) {
+	for _, attr := range attrs {
+		switch a := attr.(type) {
+		case *tg.DocumentAttributeFilename:
+			meta.FileName = a.FileName
+		case *tg.DocumentAttributeVideo:
+			meta.Width = a.W
+			meta.Height = a.H
+			meta.Duration = a.Duration
+			meta.SupportsStreaming = a.SupportsStreaming
+			if meta.MediaKind == "" {
+				meta.MediaKind = storage.MediaVideo
+			}
+			if a.RoundMessage {
+				meta.MediaKind = storage.MediaRound
+			}
+		case *tg.DocumentAttributeAudio:
+			meta.Duration = float64(a.Duration)
+			meta.AudioTitle = a.Title
+			meta.AudioPerformer = a.Performer
+			meta.MediaKind = storage.MediaAudio
+			if a.Voice {
+				meta.MediaKind = storage.MediaVoice
+			}
+		case *tg.DocumentAttributeAnimated:
+			// GIFs also carry a video attribute, animation takes precedence
+			meta.MediaKind = storage.MediaAnimation
+		case *tg.DocumentAttributeSticker:
+			meta.MediaKind = storage.MediaSticker
+		case *tg.DocumentAttributeImageSize:
+			meta.Width = a.W
+			meta.Height = a.H
+		}
+	}
+}
+
+// mediaSummary describes the media attributes of a file in one line (ending in a newline), or returns ""
+func mediaSummary(meta *storage.FileMetadata) string {
+	switch meta.MediaKind {
+	case storage.MediaVideo, storage.MediaRound, storage.MediaAnimation:
+		return fmt.Sprintf("🎬 Video: %d×%d, %s\n", meta.Width, meta.Height, FormatDuration(meta.Duration))
+	case storage.MediaAudio:
+		title := meta.AudioTitle
+		if meta.AudioPerformer != "" && title != "" {
+			title = meta.AudioPerformer + " – " + title
+		}
+		if title == "" {
+			return fmt.Sprintf("🎵 Audio: %s\n", FormatDuration(meta.Duration))
+		}
+		return fmt.Sprintf("🎵 Audio: %s (%s)\n", title, FormatDuration(meta.Duration))
+	case storage.MediaVoice:
+		return fmt.Sprintf("🎤 Voice: %s\n", FormatDuration(meta.Duration))
+	case storage.MediaSticker:
+		return "🏷 Sticker\n"
+	}
+	return ""
+}
+
+// setSource records the message a file's reference can be refreshed from.
+// With mirroring enabled that's the copy in the mirror channel, otherwise the original message.
+func (h *Handler) setSource(ctx context.Context, msg *tg.Message, entities tg.Entities, meta *storage.FileMetadata) {
+	if h.mirror != nil {
+		mirrored, err := h.mirrorMessage(ctx, msg, entities)
+		if err == nil {
+			meta.SourceChannelID = h.mirror.ChannelID
+			meta.SourceAccessHash = h.mirror.AccessHash
+			meta.SourceMessageID = mirrored.ID
+			log.Printf("🪞 Mirrored message %d to channel message %d", msg.ID, mirrored.ID)
+			return
+		}
+		log.Printf("⚠️ Failed to mirror message %d, keeping the original as source: %v", msg.ID, err)
+	}
+
+	meta.SourceMessageID = msg.ID
+	if channel, ok := msg.PeerID.(*tg.PeerChannel); ok {
+		meta.SourceChannelID = channel.ChannelID
+		if c, ok := entities.Channels[channel.ChannelID]; ok {
+			meta.SourceAccessHash = c.AccessHash
+		}
+	}
+}
+
+// mirrorMessage copies a message into the mirror channel and returns the copy
+func (h *Handler) mirrorMessage(ctx context.Context, msg *tg.Message, entities tg.Entities) (*tg.Message, error) {
+	from := inputPeer(msg.PeerID, entities)
+	if from == nil {
+		return nil, fmt.Errorf("unsupported peer %T", msg.PeerID)
+	}
+
+	randomID, err := NewUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	// DropAuthor sends a copy instead of a forward, so the channel doesn't depend on the original
+	updates, err := h.api.MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
+		DropAuthor: true,
+		Silent:     true,
+		FromPeer:   from,
+		ID:         []int{msg.ID},
+		RandomID:   []int64{randomID},
+		ToPeer:     h.mirror,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mirrored := messageFromUpdates(updates)
+	if mirrored == nil {
+		return nil, fmt.Errorf("mirrored message not found in %T", updates)
+	}
+	return mirrored, nil
+}
+
+// inputPeer converts a peer to an input peer, taking access hashes from the update's entities
+func inputPeer(peer tg.PeerClass, entities tg.Entities) tg.InputPeerClass {
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		input := &tg.InputPeerUser{UserID: p.UserID}
+		if user, ok := entities.Users[p.UserID]; ok {
+			input.AccessHash = user.AccessHash
+		}
+		return input
+	case *tg.PeerChat:
+		return &tg.InputPeerChat{ChatID: p.ChatID}
+	case *tg.PeerChannel:
+		input := &tg.InputPeerChannel{ChannelID: p.ChannelID}
+		if channel, ok := entities.Channels[p.ChannelID]; ok {
+			input.AccessHash = channel.AccessHash
+		}
+		return input
+	}
+	return nil
+}
+
+// collectionSummary names the collection a file was added to (ending in a blank line), or returns ""
+func collectionSummary(meta *storage.FileMetadata) string {
+	if meta.Collection == "" {
+		return ""
+	}
+	return fmt.Sprintf("📚 Collection: `%s`\n\n", meta.Collection)
+}
+
+// refuseOverQuota tells the file's owner when the link would take them over a quota, and reports
+// whether it did. Links are still created when usage can't be checked.
+func (h *Handler) refuseOverQuota(ctx context.Context, msg *tg.Message, meta *storage.FileMetadata) (bool, error) {
+	if meta.OwnerID == 0 || (h.quotas.MaxLinks == 0 && h.quotas.MaxLinkedBytes == 0) {
+		return false, nil
+	}
+
+	usage, err := h.storage.GetUsage(meta.OwnerID)
+	if err != nil {
+		log.Printf("⚠️ Failed to check quota: %v", err)
+		return false, nil
+	}
+
+	var quotaErr *storage.QuotaError
+	if err := h.quotas.CheckNewLink(usage, meta.FileSize); errors.As(err, &quotaErr) {
+		log.Printf("🚫 User %d is over quota: %v", meta.OwnerID, err)
+		return true, h.reply(ctx, msg, fmt.Sprintf("🚫 *No link created:* %s.\n\nSend /usage to see your usage.", quotaErr.Reason))
+	}
+	return false, nil
+}
+
+// senderID returns the ID of the user who sent a message, or 0 if it wasn't sent by a user
+func senderID(msg *tg.Message) int64 {
+	if from, ok := msg.GetFromID(); ok {
+		if user, ok := from.(*tg.PeerUser); ok {
+			return user.UserID
+		}
+	}
+	// In private chats with the bot the peer is the sender
+	if user, ok := msg.PeerID.(*tg.PeerUser); ok {
+		return user.UserID
+	}
+	return 0
+}
+
 // getPeerFromMessage extracts the peer from a message for replying
 func (h *Handler) getPeerFromMessage(msg *tg.Message) tg.InputPeerClass {
-	peer := msg.GetPeerID()
+	return h.inputPeer(msg.GetPeerID())
+}
 
+// inputPeer turns a peer into one that can be messaged, using the cached access hashes
+func (h *Handler) inputPeer(peer tg.PeerClass) tg.InputPeerClass {
 	// For bot chats, the peer is usually the user who sent the message
 	switch p := peer.(type) {
 	case *tg.PeerUser:
+		if h.userMode && p.UserID == h.self {
+			return &tg.InputPeerSelf{}
+		}
 		return &tg.InputPeerUser{
-			UserID: p.UserID,
+			UserID:     p.UserID,
+			AccessHash: accessHash(&h.userHashes, p.UserID),
 		}
 	case *tg.PeerChat:
 		return &tg.InputPeerChat{
@@ -228,15 +586,16 @@ func (h *Handler) getPeerFromMessage(msg *tg.Message) tg.InputPeerClass {
 		}
 	case *tg.PeerChannel:
 		return &tg.InputPeerChannel{
-			ChannelID: p.ChannelID,
+			ChannelID:  p.ChannelID,
+			AccessHash: accessHash(&h.channelHashes, p.ChannelID),
 		}
 	}
 
 	return nil
 }
 
-// formatFileSize formats bytes into human-readable format
-func formatFileSize(bytes int64) string {
+// FormatFileSize formats bytes into human-readable format
+func FormatFileSize(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
 		return fmt.Sprintf("%d B", bytes)
@@ -248,3 +607,13 @@ func formatFileSize(bytes int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
+
+// FormatDuration formats seconds as m:ss or h:mm:ss
+func FormatDuration(seconds float64) string {
+	total := int64(seconds + 0.5)
+	h, m, sec := total/3600, (total/60)%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
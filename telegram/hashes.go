@@ -0,0 +1,81 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// HashBlockSize is the size of the blocks Telegram hashes files in. Chunks must be at least
+// this large to be verified.
+const HashBlockSize = 128 * 1024
+
+// ErrHashMismatch is returned when downloaded data doesn't match Telegram's hash of it
+var ErrHashMismatch = errors.New("data doesn't match Telegram's file hash")
+
+// errUnverifiable is returned when a chunk isn't made up of whole hash blocks
+var errUnverifiable = errors.New("chunk doesn't cover whole hash blocks")
+
+// fileHashes returns Telegram's SHA-256 hashes of the blocks of a file covering [offset, end)
+func fileHashes(ctx context.Context, api *tg.Client, location tg.InputFileLocationClass, offset, end int64) ([]tg.FileHash, error) {
+	var hashes []tg.FileHash
+	for next := offset; next < end; {
+		batch, err := api.UploadGetFileHashes(ctx, &tg.UploadGetFileHashesRequest{
+			Location: location,
+			Offset:   next,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file hashes at offset %d: %w", next, err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		hashes = append(hashes, batch...)
+
+		last := batch[len(batch)-1]
+		if last.Offset+int64(last.Limit) <= next {
+			break
+		}
+		next = last.Offset + int64(last.Limit)
+	}
+	return hashes, nil
+}
+
+// verifyChunk checks data downloaded from offset against Telegram's block hashes.
+// Every byte must be covered by a block that lies within the chunk. In the file's tail chunk
+// the last block may reach past the data, since Telegram hashes it only as far as the file goes.
+func verifyChunk(data []byte, offset int64, tail bool, hashes []tg.FileHash) error {
+	end := offset + int64(len(data))
+	covered := offset
+
+	for _, h := range hashes {
+		blockEnd := h.Offset + int64(h.Limit)
+		if blockEnd <= offset || h.Offset >= end {
+			continue
+		}
+		if h.Offset < offset || (blockEnd > end && !tail) {
+			return errUnverifiable
+		}
+		if h.Offset != covered {
+			continue
+		}
+		if blockEnd > end {
+			blockEnd = end
+		}
+
+		sum := sha256.Sum256(data[h.Offset-offset : blockEnd-offset])
+		if !bytes.Equal(sum[:], h.Hash) {
+			return fmt.Errorf("%w at offset %d", ErrHashMismatch, h.Offset)
+		}
+		covered = blockEnd
+	}
+
+	if covered != end {
+		return errUnverifiable
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package telegram
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+// testFile returns size bytes of varied content
+func testFile(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*31 + i/HashBlockSize)
+	}
+	return data
+}
+
+// blockHashes hashes file the way Telegram does: HashBlockSize blocks, the last one only as far
+// as the file goes. With exactLimit the last block's Limit is its real length instead of HashBlockSize.
+func blockHashes(file []byte, exactLimit bool) []tg.FileHash {
+	var hashes []tg.FileHash
+	for offset := 0; offset < len(file); offset += HashBlockSize {
+		end := min(offset+HashBlockSize, len(file))
+		sum := sha256.Sum256(file[offset:end])
+		limit := HashBlockSize
+		if exactLimit {
+			limit = end - offset
+		}
+		hashes = append(hashes, tg.FileHash{Offset: int64(offset), Limit: limit, Hash: sum[:]})
+	}
+	return hashes
+}
+
+func TestVerifyChunk(t *testing.T) {
+	const block = HashBlockSize
+	const chunk = 8 * block
+
+	tests := []struct {
+		name     string
+		fileSize int
+		offset   int
+		length   int
+		tail     bool
+		hashes   func(file []byte) []tg.FileHash
+		corrupt  int // Offset within the chunk to flip a byte at, -1 for none
+		wantErr  error
+	}{
+		{
+			name: "first chunk", fileSize: 3 * chunk, offset: 0, length: chunk,
+			corrupt: -1,
+		},
+		{
+			name: "middle chunk", fileSize: 3 * chunk, offset: chunk, length: chunk,
+			corrupt: -1,
+		},
+		{
+			name: "tail chunk with a short last block", fileSize: 2*chunk + 3*block + 100, offset: 2 * chunk, length: 3*block + 100, tail: true,
+			corrupt: -1,
+		},
+		{
+			name: "tail chunk whose last block has its real length", fileSize: chunk + 5, offset: chunk, length: 5, tail: true,
+			hashes:  func(file []byte) []tg.FileHash { return blockHashes(file, true) },
+			corrupt: -1,
+		},
+		{
+			name: "tail chunk ending on a block boundary", fileSize: chunk + 2*block, offset: chunk, length: 2 * block, tail: true,
+			corrupt: -1,
+		},
+		{
+			name: "file smaller than a block", fileSize: 1000, offset: 0, length: 1000, tail: true,
+			corrupt: -1,
+		},
+		{
+			name: "short block outside the tail chunk", fileSize: chunk + 100, offset: chunk, length: 100,
+			corrupt: -1, wantErr: errUnverifiable,
+		},
+		{
+			name: "chunk not aligned to blocks", fileSize: 2 * chunk, offset: block / 2, length: chunk,
+			corrupt: -1, wantErr: errUnverifiable,
+		},
+		{
+			name: "chunk smaller than a block", fileSize: 2 * chunk, offset: 0, length: block / 4,
+			corrupt: -1, wantErr: errUnverifiable,
+		},
+		{
+			name: "missing block hash", fileSize: 2 * chunk, offset: 0, length: chunk,
+			hashes: func(file []byte) []tg.FileHash {
+				hashes := blockHashes(file, false)
+				return append(hashes[:3:3], hashes[4:]...)
+			},
+			corrupt: -1, wantErr: errUnverifiable,
+		},
+		{
+			name: "overlapping hash batches", fileSize: 2 * chunk, offset: 0, length: chunk,
+			hashes: func(file []byte) []tg.FileHash {
+				hashes := blockHashes(file, false)
+				return append(hashes[:5:5], hashes[3:]...)
+			},
+			corrupt: -1,
+		},
+		{
+			name: "no hashes", fileSize: chunk, offset: 0, length: chunk,
+			hashes:  func([]byte) []tg.FileHash { return nil },
+			corrupt: -1, wantErr: errUnverifiable,
+		},
+		{
+			name: "corrupt first byte", fileSize: 2 * chunk, offset: chunk, length: chunk,
+			corrupt: 0, wantErr: ErrHashMismatch,
+		},
+		{
+			name: "corrupt last byte of a block", fileSize: 2 * chunk, offset: 0, length: chunk,
+			corrupt: 3*block - 1, wantErr: ErrHashMismatch,
+		},
+		{
+			name: "corrupt short last block", fileSize: chunk + block + 10, offset: chunk, length: block + 10, tail: true,
+			corrupt: block + 9, wantErr: ErrHashMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := testFile(tt.fileSize)
+			hashes := blockHashes(file, false)
+			if tt.hashes != nil {
+				hashes = tt.hashes(file)
+			}
+
+			data := append([]byte(nil), file[tt.offset:tt.offset+tt.length]...)
+			if tt.corrupt >= 0 {
+				data[tt.corrupt] ^= 0xff
+			}
+
+			err := verifyChunk(data, int64(tt.offset), tt.tail, hashes)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("got %v, want no error", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
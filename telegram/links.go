@@ -0,0 +1,185 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// messageLinkPattern matches t.me/<username>/<msg> and t.me/c/<id>/<msg> links,
+// including the forum topic form with a thread ID before the message ID
+var messageLinkPattern = regexp.MustCompile(
+	`^(?:https?://)?(?:t\.me|telegram\.me)/(?:c/(\d+)|([A-Za-z][A-Za-z0-9_]{3,31}))/(?:\d+/)?(\d+)/?(?:\?.*)?$`)
+
+// MessageLink points at a message in a channel
+type MessageLink struct {
+	Username  string // Public username of the channel, empty for t.me/c/ links
+	ChannelID int64  // Channel ID of t.me/c/ links
+	MessageID int
+}
+
+// ParseMessageLink parses a pasted t.me message URL
+func ParseMessageLink(text string) (MessageLink, bool) {
+	m := messageLinkPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return MessageLink{}, false
+	}
+
+	link := MessageLink{Username: m[2]}
+	if m[1] != "" {
+		id, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return MessageLink{}, false
+		}
+		link.ChannelID = id
+	}
+	id, err := strconv.Atoi(m[3])
+	if err != nil || id < 1 {
+		return MessageLink{}, false
+	}
+	link.MessageID = id
+	return link, true
+}
+
+// ProcessMessageLink creates a download link for the file in the channel message a t.me URL points at
+func (h *Handler) ProcessMessageLink(ctx context.Context, msg *tg.Message, link MessageLink) error {
+	c, err := h.resolveLinkChannel(ctx, link)
+	if err != nil {
+		log.Printf("⚠️ Failed to resolve channel of message link: %v", err)
+		return h.reply(ctx, msg, "❌ Couldn't access that channel. Is it public, or is the bot a member?")
+	}
+	channel := &tg.InputPeerChannel{ChannelID: c.ID, AccessHash: c.AccessHash}
+
+	if !h.mayLinkFrom(ctx, msg, c, channel) {
+		log.Printf("⛔ Refused link to channel %d message %d from user %d", c.ID, link.MessageID, senderID(msg))
+		return h.reply(ctx, msg, "⛔ You can only link to messages in public channels or in channels you're a member of.")
+	}
+
+	post, err := h.channelMessage(ctx, channel, link.MessageID)
+	if err != nil {
+		log.Printf("⚠️ Failed to fetch channel %d message %d: %v", channel.ChannelID, link.MessageID, err)
+		return h.reply(ctx, msg, "❌ Couldn't fetch that message.")
+	}
+	if post == nil || post.Media == nil {
+		return h.reply(ctx, msg, "⚠️ That message doesn't contain a file.")
+	}
+
+	meta, err := ExtractFileMetadata(post.Media)
+	if errors.Is(err, ErrUnsupportedMedia) || meta == nil {
+		return h.reply(ctx, msg, "⚠️ Unsupported media type. Please link to documents or photos.")
+	}
+
+	meta.LinkID = uuid.New().String()
+	meta.OwnerID = senderID(msg)
+	if refused, err := h.refuseOverQuota(ctx, msg, meta); refused {
+		return err
+	}
+
+	// The channel post stays the source the file reference is refreshed from
+	meta.SourceChannelID = channel.ChannelID
+	meta.SourceAccessHash = channel.AccessHash
+	meta.SourceMessageID = post.ID
+
+	log.Printf("🔗 Linking channel %d message %d", channel.ChannelID, post.ID)
+	return h.saveAndReply(ctx, msg, meta)
+}
+
+// mayLinkFrom reports whether the sender of msg may link to files in a channel. The storage channel
+// never qualifies: its message IDs are sequential, and its posts belong to other users' links.
+// Other channels have to be public, indexed, or have the sender as a member.
+func (h *Handler) mayLinkFrom(ctx context.Context, msg *tg.Message, c *tg.Channel, channel *tg.InputPeerChannel) bool {
+	if c.ID == h.storageChannelID || (h.mirror != nil && c.ID == h.mirror.ChannelID) {
+		return false
+	}
+	if isPublicChannel(c) || h.watched[c.ID] {
+		return true
+	}
+
+	id := senderID(msg)
+	if id == 0 {
+		return false
+	}
+	member, err := h.isChannelMember(ctx, channel, h.inputPeer(&tg.PeerUser{UserID: id}))
+	if err != nil {
+		log.Printf("⚠️ Failed to check membership of user %d in channel %d: %v", id, c.ID, err)
+	}
+	return member
+}
+
+// isPublicChannel reports whether a channel has a public username
+func isPublicChannel(c *tg.Channel) bool {
+	if c.Username != "" {
+		return true
+	}
+	for _, u := range c.Usernames {
+		if u.Active {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLinkChannel looks up the channel a message link points at
+func (h *Handler) resolveLinkChannel(ctx context.Context, link MessageLink) (*tg.Channel, error) {
+	if link.Username == "" {
+		id := BareChannelID(link.ChannelID)
+		res, err := h.api.ChannelsGetChannels(ctx, []tg.InputChannelClass{&tg.InputChannel{ChannelID: id}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get channel %d: %w", id, err)
+		}
+		for _, chat := range res.GetChats() {
+			if c, ok := chat.(*tg.Channel); ok && c.ID == id {
+				return c, nil
+			}
+		}
+		return nil, fmt.Errorf("channel %d not found", id)
+	}
+
+	res, err := h.api.ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{Username: link.Username})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve @%s: %w", link.Username, err)
+	}
+	peer, ok := res.Peer.(*tg.PeerChannel)
+	if !ok {
+		return nil, fmt.Errorf("@%s is not a channel", link.Username)
+	}
+	for _, chat := range res.Chats {
+		if c, ok := chat.(*tg.Channel); ok && c.ID == peer.ChannelID {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("channel @%s not found", link.Username)
+}
+
+// channelMessage fetches one message of a channel, or returns nil if it doesn't exist
+func (h *Handler) channelMessage(ctx context.Context, channel *tg.InputPeerChannel, id int) (*tg.Message, error) {
+	res, err := h.api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+		Channel: &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+		ID:      []tg.InputMessageClass{&tg.InputMessageID{ID: id}},
+	})
+	if tgerr.Is(err, "MESSAGE_IDS_EMPTY") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	modified, ok := res.AsModified()
+	if !ok {
+		return nil, nil
+	}
+	for _, m := range modified.GetMessages() {
+		if post, ok := m.(*tg.Message); ok && post.ID == id {
+			return post, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	getFileDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "telebot_upload_get_file_duration_seconds",
+		Help:    "Latency of upload.getFile chunk requests, failed ones included.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	})
+	getFileErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telebot_upload_get_file_errors_total",
+		Help: "Failed upload.getFile chunk requests by RPC error type.",
+	}, []string{"type"})
+	floodWaitSeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telebot_flood_wait_seconds_total",
+		Help: "Seconds Telegram asked downloads and backfills to wait with FLOOD_WAIT.",
+	})
+	poolSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telebot_pool_size",
+		Help: "Requests the download pool allows in flight, its effective size.",
+	})
+	poolConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telebot_pool_connections",
+		Help: "Connections the download pool has opened.",
+	})
+	messagesHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telebot_messages_handled_total",
+		Help: "Updates handled by the bot by type.",
+	}, []string{"type"})
+)
+
+// uploadGetFile requests a chunk, recording its latency and any error
+func uploadGetFile(ctx context.Context, api *tg.Client, req *tg.UploadGetFileRequest) (tg.UploadFileClass, error) {
+	start := time.Now()
+	res, err := api.UploadGetFile(ctx, req)
+	getFileDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		getFileErrors.WithLabelValues(rpcErrorType(err)).Inc()
+		observeFloodWait(err)
+	}
+	return res, err
+}
+
+// rpcErrorType names an error for the metrics, like "FLOOD_WAIT" or "FILE_REFERENCE_EXPIRED"
+func rpcErrorType(err error) string {
+	if rpcErr, ok := tgerr.As(err); ok {
+		return rpcErr.Type
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "other"
+}
+
+// observeFloodWait adds the wait of a FLOOD_WAIT error to the metrics
+func observeFloodWait(err error) {
+	if wait, ok := tgerr.AsFloodWait(err); ok {
+		floodWaitSeconds.Add(wait.Seconds())
+	}
+}
+
+// messageType classifies a message for the metrics
+func messageType(msg *tg.Message) string {
+	switch msg.Media.(type) {
+	case nil:
+		if len(msg.Message) > 0 && msg.Message[0] == '/' {
+			return "command"
+		}
+		if _, ok := ParseMessageLink(msg.Message); ok {
+			return "link"
+		}
+		return "text"
+	case *tg.MessageMediaDocument:
+		return "document"
+	case *tg.MessageMediaPhoto:
+		return "photo"
+	default:
+		return "other_media"
+	}
+}
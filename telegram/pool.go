@@ -0,0 +1,305 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+const (
+	// adaptInterval is how often the adaptive pool re-evaluates its size
+	adaptInterval = 5 * time.Second
+
+	// latencySpike is how far above the best observed latency counts as a spike
+	latencySpike = 2.0
+)
+
+// PoolOptions configures the download connection pool
+type PoolOptions struct {
+	Size     int  // Connections, or the upper bound in adaptive mode
+	MinSize  int  // Lower bound in adaptive mode
+	Adaptive bool // Grow while throughput improves, shrink on FLOOD_WAIT or latency spikes
+}
+
+// PoolStats is a snapshot of the connection pool
+type PoolStats struct {
+	Adaptive      bool    `json:"adaptive"`
+	Limit         int     `json:"limit"` // Requests allowed in flight, the effective pool size
+	Max           int     `json:"max"`
+	Connections   int     `json:"connections"` // Opened so far; the pool dials on demand
+	Active        int     `json:"active"`
+	Idle          int     `json:"idle"`
+	Waiting       int     `json:"waiting"`
+	Requests      int64   `json:"requests"`
+	Errors        int64   `json:"errors"`
+	FloodWaits    int64   `json:"flood_waits"`
+	LatencyMillis float64 `json:"latency_ms"`
+	ThroughputBps float64 `json:"throughput_bps"`
+}
+
+// poolInvoker sits in front of the connection pool, capping requests in flight at a limit
+// the adaptive mode moves between MinSize and Size. The pool only dials when every open
+// connection is busy, so the cap also bounds the number of connections in use.
+type poolInvoker struct {
+	next CloseInvoker
+	opts PoolOptions
+
+	mu      sync.Mutex
+	limit   int
+	active  int
+	peak    int // Most requests ever in flight, i.e. connections opened
+	waiters []chan struct{}
+
+	requests   atomic.Int64
+	errors     atomic.Int64
+	floodWaits atomic.Int64
+
+	// Measurements of the current adaptive window
+	windowBytes    int64
+	windowRequests int64
+	windowLatency  time.Duration
+	windowFlood    bool
+	windowBusy     bool // The limit was reached during the window
+	bestLatency    time.Duration
+	lastThroughput float64
+	lastLatency    time.Duration
+	grew           bool // The last adjustment grew the pool
+
+	stop chan struct{}
+}
+
+// newPoolInvoker wraps a connection pool of opts.Size connections
+func newPoolInvoker(next CloseInvoker, opts PoolOptions) *poolInvoker {
+	if opts.MinSize < 1 {
+		opts.MinSize = 1
+	}
+	if opts.MinSize > opts.Size {
+		opts.MinSize = opts.Size
+	}
+
+	p := &poolInvoker{next: next, opts: opts, limit: opts.Size, stop: make(chan struct{})}
+	if opts.Adaptive {
+		// Start small and grow while it pays off
+		p.limit = opts.MinSize
+	}
+	poolSize.Set(float64(p.limit))
+	go p.adaptLoop()
+	return p
+}
+
+// Invoke implements tg.Invoker
+func (p *poolInvoker) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	if err := p.acquire(ctx); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := p.next.Invoke(ctx, input, output)
+	p.release()
+
+	p.requests.Add(1)
+	if err != nil && ctx.Err() == nil {
+		p.errors.Add(1)
+	}
+	_, flood := tgerr.AsFloodWait(err)
+	if flood {
+		p.floodWaits.Add(1)
+	}
+
+	var size int
+	if box, ok := output.(*tg.UploadFileBox); ok && err == nil {
+		if file, ok := box.File.(*tg.UploadFile); ok {
+			size = len(file.Bytes)
+		}
+	}
+
+	p.mu.Lock()
+	p.windowBytes += int64(size)
+	p.windowRequests++
+	p.windowLatency += time.Since(start)
+	p.windowFlood = p.windowFlood || flood
+	p.mu.Unlock()
+
+	return err
+}
+
+// Close stops the adaptive loop and closes the pool
+func (p *poolInvoker) Close() error {
+	close(p.stop)
+	return p.next.Close()
+}
+
+// acquire waits until a request may be sent
+func (p *poolInvoker) acquire(ctx context.Context) error {
+	p.mu.Lock()
+	if p.active < p.limit {
+		p.active++
+		if p.active > p.peak {
+			p.peak = p.active
+			poolConnections.Set(float64(p.peak))
+		}
+		if p.active == p.limit {
+			p.windowBusy = true
+		}
+		p.mu.Unlock()
+		return nil
+	}
+	p.windowBusy = true
+	ready := make(chan struct{})
+	p.waiters = append(p.waiters, ready)
+	p.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, w := range p.waiters {
+			if w == ready {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// Woken up while giving up: pass the slot on
+		p.releaseLocked()
+		return ctx.Err()
+	}
+}
+
+// release frees a request slot
+func (p *poolInvoker) release() {
+	p.mu.Lock()
+	p.releaseLocked()
+	p.mu.Unlock()
+}
+
+// releaseLocked hands the slot to the next waiter, or frees it if the limit shrank
+func (p *poolInvoker) releaseLocked() {
+	if len(p.waiters) > 0 && p.active <= p.limit {
+		close(p.waiters[0])
+		p.waiters = p.waiters[1:]
+		return
+	}
+	p.active--
+}
+
+// setLimit changes the number of requests allowed in flight, waking waiters if it grew
+func (p *poolInvoker) setLimit(limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = limit
+	poolSize.Set(float64(limit))
+	for len(p.waiters) > 0 && p.active < p.limit {
+		p.active++
+		if p.active > p.peak {
+			p.peak = p.active
+			poolConnections.Set(float64(p.peak))
+		}
+		close(p.waiters[0])
+		p.waiters = p.waiters[1:]
+	}
+}
+
+// adaptLoop periodically measures the pool and, in adaptive mode, resizes it
+func (p *poolInvoker) adaptLoop() {
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.adapt()
+		}
+	}
+}
+
+// adapt halves the pool after FLOOD_WAIT, shrinks it on latency spikes or falling throughput,
+// and grows it while it's saturated and throughput keeps improving
+func (p *poolInvoker) adapt() {
+	p.mu.Lock()
+	limit := p.limit
+	requests := p.windowRequests
+	throughput := float64(p.windowBytes) / adaptInterval.Seconds()
+	var latency time.Duration
+	if requests > 0 {
+		latency = p.windowLatency / time.Duration(requests)
+	}
+	flood, busy := p.windowFlood, p.windowBusy
+	p.windowBytes, p.windowRequests, p.windowLatency = 0, 0, 0
+	p.windowFlood, p.windowBusy = false, p.active >= p.limit
+	if latency > 0 && (p.bestLatency == 0 || latency < p.bestLatency) {
+		p.bestLatency = latency
+	}
+	best := p.bestLatency
+	last := p.lastThroughput
+	grew := p.grew
+	p.lastThroughput = throughput
+	p.lastLatency = latency
+	p.mu.Unlock()
+
+	if !p.opts.Adaptive || requests == 0 {
+		return
+	}
+
+	next := limit
+	switch {
+	case flood:
+		next = limit / 2
+	case best > 0 && float64(latency) > latencySpike*float64(best):
+		next = limit - 1
+	case grew && throughput < last*0.95:
+		// Growing didn't help, step back
+		next = limit - 1
+	case busy && throughput >= last:
+		next = limit + 1
+	}
+	if next < p.opts.MinSize {
+		next = p.opts.MinSize
+	}
+	if next > p.opts.Size {
+		next = p.opts.Size
+	}
+
+	p.mu.Lock()
+	p.grew = next > limit
+	p.mu.Unlock()
+
+	if next != limit {
+		log.Printf("📐 Adaptive pool: %d -> %d connections (%.1f MB/s, %s latency, flood wait: %t)",
+			limit, next, throughput/1e6, latency.Round(time.Millisecond), flood)
+		p.setLimit(next)
+	}
+}
+
+// Stats returns a snapshot of the pool
+func (p *poolInvoker) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idle := p.peak - p.active
+	if idle < 0 {
+		idle = 0
+	}
+	return PoolStats{
+		Adaptive:      p.opts.Adaptive,
+		Limit:         p.limit,
+		Max:           p.opts.Size,
+		Connections:   p.peak,
+		Active:        p.active,
+		Idle:          idle,
+		Waiting:       len(p.waiters),
+		Requests:      p.requests.Load(),
+		Errors:        p.errors.Load(),
+		FloodWaits:    p.floodWaits.Load(),
+		LatencyMillis: float64(p.lastLatency) / float64(time.Millisecond),
+		ThroughputBps: p.lastThroughput,
+	}
+}
@@ -0,0 +1,92 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+
+	"tele-bot/storage"
+)
+
+// ErrNoSource is returned when a file has no message to refresh its reference from
+var ErrNoSource = errors.New("file has no source message")
+
+// Refresher re-fetches the message a file came from to get a fresh file_reference.
+// File references expire after a while, after which UploadGetFile fails with FILE_REFERENCE_EXPIRED.
+type Refresher struct {
+	api     *tg.Client
+	storage *storage.Storage
+}
+
+// NewRefresher creates a file reference refresher
+func NewRefresher(api *tg.Client, storage *storage.Storage) *Refresher {
+	return &Refresher{
+		api:     api,
+		storage: storage,
+	}
+}
+
+// Refresh fetches the source message of a file and saves its current file reference
+func (r *Refresher) Refresh(ctx context.Context, meta *storage.FileMetadata) ([]byte, error) {
+	msg, err := r.sourceMessage(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh, err := ExtractFileMetadata(msg.Media)
+	if err != nil || fresh == nil || fresh.FileID != meta.FileID {
+		return nil, fmt.Errorf("source message %d no longer holds file %d", meta.SourceMessageID, meta.FileID)
+	}
+
+	if err := r.storage.UpdateFileReference(meta.FileID, fresh.FileReference); err != nil {
+		log.Printf("⚠️ Failed to save refreshed file reference: %v", err)
+	}
+	meta.FileReference = fresh.FileReference
+
+	log.Printf("🔄 Refreshed file reference of %s from message %d", meta.LinkID, meta.SourceMessageID)
+	return fresh.FileReference, nil
+}
+
+// sourceMessage fetches the message recorded as the source of a file
+func (r *Refresher) sourceMessage(ctx context.Context, meta *storage.FileMetadata) (*tg.Message, error) {
+	if meta.SourceMessageID == 0 {
+		return nil, ErrNoSource
+	}
+
+	ids := []tg.InputMessageClass{&tg.InputMessageID{ID: meta.SourceMessageID}}
+
+	var res tg.MessagesMessagesClass
+	var err error
+	if meta.SourceChannelID != 0 {
+		res, err = r.api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: meta.SourceChannelID, AccessHash: meta.SourceAccessHash},
+			ID:      ids,
+		})
+	} else {
+		// Private chats and basic groups share the bot's message ID space
+		res, err = r.api.MessagesGetMessages(ctx, ids)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch source message %d: %w", meta.SourceMessageID, err)
+	}
+
+	modified, ok := res.AsModified()
+	if !ok {
+		return nil, fmt.Errorf("unexpected response type: %T", res)
+	}
+	for _, m := range modified.GetMessages() {
+		if msg, ok := m.(*tg.Message); ok && msg.ID == meta.SourceMessageID {
+			return msg, nil
+		}
+	}
+	return nil, fmt.Errorf("source message %d was deleted", meta.SourceMessageID)
+}
+
+// isFileReferenceError reports whether an RPC failed because the file reference is stale
+func isFileReferenceError(err error) bool {
+	return tgerr.Is(err, "FILE_REFERENCE_EXPIRED", "FILE_REFERENCE_INVALID", "FILE_REFERENCE_EMPTY")
+}
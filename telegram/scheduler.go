@@ -0,0 +1,220 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// InteractiveRangeSize is the largest Range request whose chunks are all treated as interactive
+	InteractiveRangeSize = 2 * 1024 * 1024
+
+	// interactiveChunks is how many leading chunks of any request are interactive.
+	// Players seek with open-ended ranges, so the first chunks are what the viewer waits on.
+	interactiveChunks = 2
+)
+
+// ErrQueueFull is returned when the scheduler can't take more waiting downloads
+var ErrQueueFull = errors.New("download queue is full")
+
+// Flow identifies who a chunk request is for, so capacity can be shared fairly
+type Flow struct {
+	Client      string // Client IP
+	Link        string // Link ID
+	Interactive bool   // Small range request, served before bulk transfers
+}
+
+// SchedulerStats is a snapshot of the scheduler
+type SchedulerStats struct {
+	Capacity int   `json:"capacity"`
+	InFlight int   `json:"in_flight"`
+	Queued   int   `json:"queued"`
+	MaxQueue int   `json:"max_queue"`
+	Rejected int64 `json:"rejected"`
+}
+
+// Scheduler caps the chunk requests in flight across all downloads. Waiting requests are
+// served interactive first, then round-robin over client IPs and, per client, over links,
+// so one client or one popular file can't starve the others.
+type Scheduler struct {
+	mu       sync.Mutex
+	capacity int
+	inFlight int
+	maxQueue int
+	queued   int
+	levels   [2]fairQueue // Interactive, bulk
+
+	rejected atomic.Int64
+}
+
+// chunkWaiter is a chunk request waiting for capacity
+type chunkWaiter struct {
+	ready    chan struct{}
+	canceled bool
+}
+
+// fairQueue round-robins over clients, and over the links of each client
+type fairQueue struct {
+	clients map[string]*clientQueue
+	order   []string
+}
+
+// clientQueue holds one client's waiting requests, FIFO per link
+type clientQueue struct {
+	links map[string][]*chunkWaiter
+	order []string
+}
+
+// NewScheduler creates a scheduler allowing capacity chunk requests in flight,
+// with at most maxQueue more waiting before new downloads are turned away
+func NewScheduler(capacity, maxQueue int) *Scheduler {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Scheduler{capacity: capacity, maxQueue: maxQueue}
+}
+
+// Admit checks whether a new download can be queued. It returns ErrQueueFull and how long
+// to wait before retrying when the queue is full.
+func (s *Scheduler) Admit() (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxQueue > 0 && s.queued >= s.maxQueue {
+		s.rejected.Add(1)
+		// Roughly the time to drain the queue at one round of chunks per second
+		retry := time.Duration(s.queued/s.capacity+1) * time.Second
+		return retry, ErrQueueFull
+	}
+	return 0, nil
+}
+
+// Acquire waits for capacity to send one chunk request and returns the function releasing it
+func (s *Scheduler) Acquire(ctx context.Context, flow Flow) (func(), error) {
+	s.mu.Lock()
+	if s.inFlight < s.capacity && s.queued == 0 {
+		s.inFlight++
+		s.mu.Unlock()
+		return s.release, nil
+	}
+
+	w := &chunkWaiter{ready: make(chan struct{})}
+	s.level(flow).push(flow, w)
+	s.queued++
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Granted while giving up: hand the slot on
+			s.releaseLocked()
+		default:
+			// Skipped when its turn comes
+			w.canceled = true
+			s.queued--
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the scheduler
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SchedulerStats{
+		Capacity: s.capacity,
+		InFlight: s.inFlight,
+		Queued:   s.queued,
+		MaxQueue: s.maxQueue,
+		Rejected: s.rejected.Load(),
+	}
+}
+
+// level returns the queue for a flow's priority
+func (s *Scheduler) level(flow Flow) *fairQueue {
+	if flow.Interactive {
+		return &s.levels[0]
+	}
+	return &s.levels[1]
+}
+
+// release frees a chunk request slot
+func (s *Scheduler) release() {
+	s.mu.Lock()
+	s.releaseLocked()
+	s.mu.Unlock()
+}
+
+// releaseLocked hands the slot to the next waiter in fair order, or frees it
+func (s *Scheduler) releaseLocked() {
+	for i := range s.levels {
+		for {
+			w := s.levels[i].pop()
+			if w == nil {
+				break
+			}
+			if w.canceled {
+				continue
+			}
+			s.queued--
+			close(w.ready)
+			return
+		}
+	}
+	s.inFlight--
+}
+
+// push queues a waiter behind the same client's and link's earlier requests
+func (q *fairQueue) push(flow Flow, w *chunkWaiter) {
+	if q.clients == nil {
+		q.clients = make(map[string]*clientQueue)
+	}
+	c, ok := q.clients[flow.Client]
+	if !ok {
+		c = &clientQueue{links: make(map[string][]*chunkWaiter)}
+		q.clients[flow.Client] = c
+		q.order = append(q.order, flow.Client)
+	}
+	if _, ok := c.links[flow.Link]; !ok {
+		c.order = append(c.order, flow.Link)
+	}
+	c.links[flow.Link] = append(c.links[flow.Link], w)
+}
+
+// pop takes the next waiter: the oldest request of the next link of the next client.
+// Both move to the back of their rotation.
+func (q *fairQueue) pop() *chunkWaiter {
+	if len(q.order) == 0 {
+		return nil
+	}
+
+	client := q.order[0]
+	c := q.clients[client]
+	link := c.order[0]
+	waiters := c.links[link]
+	w := waiters[0]
+
+	c.order = c.order[1:]
+	if len(waiters) > 1 {
+		c.links[link] = waiters[1:]
+		c.order = append(c.order, link)
+	} else {
+		delete(c.links, link)
+	}
+
+	q.order = q.order[1:]
+	if len(c.order) > 0 {
+		q.order = append(q.order, client)
+	} else {
+		delete(q.clients, client)
+	}
+	return w
+}
@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gotd/td/telegram/message/markup"
+	"github.com/gotd/td/tg"
+
+	"tele-bot/storage"
+)
+
+// statsBarWidth is the length of the longest bar in the chart
+const statsBarWidth = 12
+
+// statsButton returns the Stats button attached to upload replies
+func statsButton(linkID string) tg.ReplyMarkupClass {
+	return markup.InlineRow(markup.Callback("📊 Stats", []byte("stats:"+linkID)))
+}
+
+// cmdLinkStats handles /stats <link>, which shows a link's downloads to its owner or an admin
+func (h *Handler) cmdLinkStats(ctx context.Context, msg *tg.Message, arg string) error {
+	text, err := h.linkStatsText(senderID(msg), linkIDFromArg(arg))
+	if err != nil {
+		log.Printf("❌ Failed to load link stats: %v", err)
+		return h.reply(ctx, msg, "❌ Failed to load stats. Please try again.")
+	}
+	return h.reply(ctx, msg, text)
+}
+
+// onStatsButton sends the stats of the link behind a Stats button to the chat it was pressed in
+func (h *Handler) onStatsButton(ctx context.Context, q *tg.UpdateBotCallbackQuery, linkID string) (string, error) {
+	text, err := h.linkStatsText(q.UserID, linkID)
+	if err != nil {
+		return "", err
+	}
+	if peer := h.inputPeer(q.Peer); peer != nil {
+		_, err = h.sender.To(peer).Text(ctx, text)
+	}
+	return "", err
+}
+
+// linkStatsText describes a link's downloads, or explains why userID can't see them
+func (h *Handler) linkStatsText(userID int64, linkID string) (string, error) {
+	meta, err := h.storage.GetFileByLink(linkID)
+	if err != nil {
+		return "", err
+	}
+	if meta == nil || (meta.OwnerID != userID && !h.admins[userID]) {
+		return fmt.Sprintf("🔍 No link `%s` of yours.", linkID), nil
+	}
+
+	stats, err := h.storage.GetLinkStats(linkID, storage.StatsDays)
+	if err != nil {
+		return "", err
+	}
+	if meta.OwnerID != userID {
+		h.audit(userID, storage.AuditStats, linkID, "")
+	}
+
+	return fmt.Sprintf("📊 *Stats for* `%s`\n"+
+		"📁 %s\n\n"+
+		"⬇️ Downloads: %d\n"+
+		"📤 Served: %s\n\n"+
+		"Last %d days (UTC):\n"+
+		"👥 Unique IPs: %d\n%s",
+		meta.LinkID, meta.FileName, stats.Downloads, FormatFileSize(stats.Bytes),
+		storage.StatsDays, stats.UniqueIPs, statsChart(stats.Days)), nil
+}
+
+// statsChart draws a bar per day, scaled to the busiest one
+func statsChart(days []storage.DayStats) string {
+	var most int64
+	for _, d := range days {
+		most = max(most, d.Downloads)
+	}
+
+	var b strings.Builder
+	for _, d := range days {
+		bar := "·"
+		if d.Downloads > 0 {
+			bar = strings.Repeat("█", max(1, int(d.Downloads*statsBarWidth/most)))
+		}
+		fmt.Fprintf(&b, "`%s` %s %d\n", d.Day.Format("Mon 01-02"), bar, d.Downloads)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
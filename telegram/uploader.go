@@ -0,0 +1,281 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	// UploadPartSize is the largest part size Telegram accepts for uploads (512 KiB)
+	UploadPartSize = 512 * 1024
+
+	// MaxUploadParts is Telegram's limit on the number of parts of one file
+	MaxUploadParts = 4000
+
+	// MaxUploadSize is the largest file that can be uploaded with UploadPartSize parts
+	MaxUploadSize = UploadPartSize * MaxUploadParts
+
+	// bigFileThreshold is the size above which Telegram requires upload.saveBigFilePart
+	bigFileThreshold = 10 * 1024 * 1024
+
+	// partRetries is how many times a failed part upload is attempted
+	partRetries = 3
+)
+
+// ErrFileTooLarge is returned when a file exceeds MaxUploadSize
+var ErrFileTooLarge = errors.New("file too large for Telegram")
+
+// Uploader uploads files to Telegram and posts them to the storage channel
+type Uploader struct {
+	api     *tg.Client // API used to send messages
+	pooled  *tg.Client // Pooled API used for parallel part uploads
+	channel *tg.InputPeerChannel
+	threads int
+}
+
+// NewUploader creates an uploader that posts files to the given storage channel
+func NewUploader(api, pooled *tg.Client, channel *tg.InputPeerChannel, threads int) *Uploader {
+	if threads < 1 {
+		threads = 1
+	}
+	return &Uploader{
+		api:     api,
+		pooled:  pooled,
+		channel: channel,
+		threads: threads,
+	}
+}
+
+// Channel returns the storage channel files are posted to
+func (u *Uploader) Channel() *tg.InputPeerChannel {
+	return u.channel
+}
+
+// NewUploadID returns a random ID for a file being uploaded in parts
+func NewUploadID() (int64, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// PartCount returns the number of UploadPartSize parts a file of the given size is split into
+func PartCount(size int64) int {
+	return int((size + UploadPartSize - 1) / UploadPartSize)
+}
+
+// SavePart uploads one part of a file. Parts of one file may be saved in any order and in parallel.
+func (u *Uploader) SavePart(ctx context.Context, fileID int64, part int, size int64, data []byte) error {
+	var err error
+	for attempt := 1; attempt <= partRetries; attempt++ {
+		if size > bigFileThreshold {
+			_, err = u.pooled.UploadSaveBigFilePart(ctx, &tg.UploadSaveBigFilePartRequest{
+				FileID:         fileID,
+				FilePart:       part,
+				FileTotalParts: PartCount(size),
+				Bytes:          data,
+			})
+		} else {
+			_, err = u.pooled.UploadSaveFilePart(ctx, &tg.UploadSaveFilePartRequest{
+				FileID:   fileID,
+				FilePart: part,
+				Bytes:    data,
+			})
+		}
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		log.Printf("⚠️ Upload of part %d failed (attempt %d/%d): %v", part, attempt, partRetries, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", part, err)
+	}
+	return nil
+}
+
+// Upload streams size bytes from r to Telegram, uploading up to u.threads parts in parallel
+func (u *Uploader) Upload(ctx context.Context, r io.Reader, size int64, fileName string) (tg.InputFileClass, error) {
+	if size <= 0 {
+		return nil, errors.New("file is empty")
+	}
+	if size > MaxUploadSize {
+		return nil, ErrFileTooLarge
+	}
+
+	fileID, err := NewUploadID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type part struct {
+		index int
+		data  []byte
+	}
+	parts := make(chan part)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for i := 0; i < u.threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range parts {
+				if err := u.SavePart(ctx, fileID, p.index, size, p.data); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	// The body can only be read sequentially - read parts here and hand them to the workers
+	total := PartCount(size)
+	for index := 0; index < total; index++ {
+		length := int64(UploadPartSize)
+		if remaining := size - int64(index)*UploadPartSize; remaining < length {
+			length = remaining
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			fail(fmt.Errorf("failed to read part %d: %w", index, err))
+			break
+		}
+
+		select {
+		case parts <- part{index: index, data: data}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return InputFileFor(fileID, size, fileName), nil
+}
+
+// InputFileFor describes a file whose parts have all been saved
+func InputFileFor(fileID int64, size int64, fileName string) tg.InputFileClass {
+	if size > bigFileThreshold {
+		return &tg.InputFileBig{ID: fileID, Parts: PartCount(size), Name: fileName}
+	}
+	return &tg.InputFile{ID: fileID, Parts: PartCount(size), Name: fileName}
+}
+
+// Publish sends an uploaded file to the storage channel as a document and returns the channel message
+func (u *Uploader) Publish(ctx context.Context, file tg.InputFileClass, fileName, mimeType string) (*tg.Message, error) {
+	randomID, err := NewUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	updates, err := u.api.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Peer: u.channel,
+		Media: &tg.InputMediaUploadedDocument{
+			ForceFile: true,
+			File:      file,
+			MimeType:  mimeType,
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: fileName},
+			},
+		},
+		RandomID: randomID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send file to storage channel: %w", err)
+	}
+
+	msg := messageFromUpdates(updates)
+	if msg == nil {
+		return nil, fmt.Errorf("storage channel message not found in %T", updates)
+	}
+	return msg, nil
+}
+
+// messageFromUpdates finds the message a send request created
+func messageFromUpdates(updates tg.UpdatesClass) *tg.Message {
+	var list []tg.UpdateClass
+	switch u := updates.(type) {
+	case *tg.Updates:
+		list = u.Updates
+	case *tg.UpdatesCombined:
+		list = u.Updates
+	case *tg.UpdateShort:
+		list = []tg.UpdateClass{u.Update}
+	}
+
+	for _, update := range list {
+		var m tg.MessageClass
+		switch u := update.(type) {
+		case *tg.UpdateNewChannelMessage:
+			m = u.Message
+		case *tg.UpdateNewMessage:
+			m = u.Message
+		}
+		if msg, ok := m.(*tg.Message); ok {
+			return msg
+		}
+	}
+	return nil
+}
+
+// ResolveChannel looks up a channel the bot is a member of by its ID.
+// Both the bare ID and the "-100" prefixed Bot API form are accepted.
+func ResolveChannel(ctx context.Context, api *tg.Client, channelID int64) (*tg.InputPeerChannel, error) {
+	channelID = BareChannelID(channelID)
+
+	res, err := api.ChannelsGetChannels(ctx, []tg.InputChannelClass{
+		&tg.InputChannel{ChannelID: channelID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel %d: %w", channelID, err)
+	}
+
+	for _, chat := range res.GetChats() {
+		if channel, ok := chat.(*tg.Channel); ok && channel.ID == channelID {
+			return &tg.InputPeerChannel{ChannelID: channel.ID, AccessHash: channel.AccessHash}, nil
+		}
+	}
+	return nil, fmt.Errorf("channel %d not found", channelID)
+}
+
+// BareChannelID strips the "-100" prefix the Bot API puts in front of channel IDs
+func BareChannelID(id int64) int64 {
+	const botAPIOffset = -1000000000000
+	if id < botAPIOffset {
+		return botAPIOffset - id
+	}
+	if id < 0 {
+		return -id
+	}
+	return id
+}
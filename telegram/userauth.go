@@ -0,0 +1,71 @@
+package telegram
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+	"golang.org/x/term"
+)
+
+// terminalAuth signs in a user account, asking for the login code and 2FA password on the terminal
+type terminalAuth struct {
+	phone string
+	input *bufio.Reader
+}
+
+// newTerminalAuth creates an authenticator for the given phone number
+func newTerminalAuth(phone string) *terminalAuth {
+	return &terminalAuth{phone: phone, input: bufio.NewReader(os.Stdin)}
+}
+
+// Phone returns the configured phone number
+func (a *terminalAuth) Phone(ctx context.Context) (string, error) {
+	return a.phone, nil
+}
+
+// Code asks for the login code Telegram sent to the account
+func (a *terminalAuth) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	fmt.Print("🔑 Enter the login code Telegram sent you: ")
+	code, err := a.input.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read code: %w", err)
+	}
+	return strings.TrimSpace(code), nil
+}
+
+// Password asks for the 2FA password without echoing it
+func (a *terminalAuth) Password(ctx context.Context) (string, error) {
+	fmt.Print("🔒 Enter your 2FA password: ")
+	defer fmt.Println()
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		password, err := a.input.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return strings.TrimSpace(password), nil
+	}
+
+	password, err := term.ReadPassword(fd)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimSpace(string(password)), nil
+}
+
+// AcceptTermsOfService refuses sign-up; the account has to exist already
+func (a *terminalAuth) AcceptTermsOfService(ctx context.Context, tos tg.HelpTermsOfService) error {
+	return errors.New("account not registered; sign up with an official Telegram app first")
+}
+
+// SignUp refuses sign-up; the account has to exist already
+func (a *terminalAuth) SignUp(ctx context.Context) (auth.UserInfo, error) {
+	return auth.UserInfo{}, errors.New("account not registered; sign up with an official Telegram app first")
+}
@@ -0,0 +1,52 @@
+package telegram
+
+import (
+	"sync"
+
+	"github.com/gotd/td/tg"
+)
+
+// SetUserMode makes the handler act for a signed-in user account instead of a bot.
+// Only Saved Messages and the given chats (user, group or channel IDs) are handled,
+// since a user account receives updates from every chat it's in.
+func (h *Handler) SetUserMode(selfID int64, allowedChats []int64) {
+	h.userMode = true
+	h.self = selfID
+	h.allowedChats = make(map[int64]bool, len(allowedChats))
+	for _, id := range allowedChats {
+		h.allowedChats[BareChannelID(id)] = true
+	}
+}
+
+// allowedInUserMode reports whether a message is in Saved Messages or an allowed chat
+func (h *Handler) allowedInUserMode(msg *tg.Message) bool {
+	switch p := msg.PeerID.(type) {
+	case *tg.PeerUser:
+		return p.UserID == h.self || h.allowedChats[p.UserID]
+	case *tg.PeerChat:
+		return h.allowedChats[p.ChatID]
+	case *tg.PeerChannel:
+		return h.allowedChats[p.ChannelID]
+	}
+	return false
+}
+
+// rememberPeers caches the access hashes of an update's users and channels, needed to
+// message them later, like a user account replying outside Saved Messages or admins
+// being told about access requests
+func (h *Handler) rememberPeers(entities tg.Entities) {
+	for id, user := range entities.Users {
+		h.userHashes.Store(id, user.AccessHash)
+	}
+	for id, channel := range entities.Channels {
+		h.channelHashes.Store(id, channel.AccessHash)
+	}
+}
+
+// accessHash returns a cached access hash, or 0 if it isn't known
+func accessHash(hashes *sync.Map, id int64) int64 {
+	if v, ok := hashes.Load(id); ok {
+		return v.(int64)
+	}
+	return 0
+}
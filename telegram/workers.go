@@ -0,0 +1,279 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+
+	"tele-bot/storage"
+)
+
+// Worker is a bot account that download chunks can be sent through
+type Worker struct {
+	Name string
+	api  *tg.Client // nil for the main bot, whose reader brings its own API
+
+	active     atomic.Int64 // Chunk requests in flight
+	requests   atomic.Int64
+	failures   atomic.Int64
+	floodUntil atomic.Int64 // Unix nanoseconds until which the worker is flood-limited
+
+	// Access hashes and file references are per account, so each worker resolves files itself
+	mu        sync.Mutex
+	channels  map[int64]int64                         // Channel ID -> this worker's access hash
+	locations map[int64]*tg.InputDocumentFileLocation // File ID -> this worker's location
+}
+
+// WorkerStats is a snapshot of a worker's load
+type WorkerStats struct {
+	Name          string    `json:"name"`
+	Active        int64     `json:"active"`
+	Requests      int64     `json:"requests"`
+	Errors        int64     `json:"errors"`
+	FloodWaitTill time.Time `json:"flood_wait_until,omitempty"`
+}
+
+// Workers spreads download chunk requests over the main bot and any extra bot accounts
+type Workers struct {
+	primary *Worker
+
+	mu      sync.RWMutex
+	helpers []*Worker
+	next    atomic.Uint32 // Round-robin start for workers with equal load
+}
+
+// NewWorkers creates a pool holding only the main bot
+func NewWorkers() *Workers {
+	return &Workers{primary: &Worker{Name: "main"}}
+}
+
+// add makes a connected helper bot available for downloads
+func (ws *Workers) add(w *Worker) {
+	ws.mu.Lock()
+	ws.helpers = append(ws.helpers, w)
+	ws.mu.Unlock()
+}
+
+// remove takes a disconnected helper bot out of the pool
+func (ws *Workers) remove(w *Worker) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for i, helper := range ws.helpers {
+		if helper == w {
+			ws.helpers = append(ws.helpers[:i], ws.helpers[i+1:]...)
+			return
+		}
+	}
+}
+
+// pick returns the least busy worker able to serve a file.
+// Helper bots can only reach files whose source is a channel they're a member of.
+func (ws *Workers) pick(meta *storage.FileMetadata) *Worker {
+	if meta == nil || meta.SourceChannelID == 0 {
+		return ws.primary
+	}
+
+	ws.mu.RLock()
+	candidates := make([]*Worker, 0, len(ws.helpers)+1)
+	candidates = append(candidates, ws.primary)
+	candidates = append(candidates, ws.helpers...)
+	ws.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	start := int(ws.next.Add(1))
+	var best *Worker
+	for i := range candidates {
+		w := candidates[(start+i)%len(candidates)]
+		if w.floodUntil.Load() > now {
+			continue
+		}
+		if best == nil || w.active.Load() < best.active.Load() {
+			best = w
+		}
+	}
+	if best == nil {
+		return ws.primary
+	}
+	return best
+}
+
+// Stats returns the load of every worker, main bot first
+func (ws *Workers) Stats() []WorkerStats {
+	ws.mu.RLock()
+	workers := append([]*Worker{ws.primary}, ws.helpers...)
+	ws.mu.RUnlock()
+
+	stats := make([]WorkerStats, 0, len(workers))
+	for _, w := range workers {
+		s := WorkerStats{
+			Name:     w.Name,
+			Active:   w.active.Load(),
+			Requests: w.requests.Load(),
+			Errors:   w.failures.Load(),
+		}
+		if until := w.floodUntil.Load(); until > time.Now().UnixNano() {
+			s.FloodWaitTill = time.Unix(0, until)
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// begin marks a chunk request as started and returns its completion function
+func (w *Worker) begin() func(err error) {
+	w.active.Add(1)
+	w.requests.Add(1)
+	return func(err error) {
+		w.active.Add(-1)
+		if err == nil {
+			return
+		}
+		w.failures.Add(1)
+		if wait, ok := tgerr.AsFloodWait(err); ok {
+			w.floodUntil.Store(time.Now().Add(wait).UnixNano())
+			log.Printf("⏳ Worker %s flood-limited for %s", w.Name, wait)
+		}
+	}
+}
+
+// chunk downloads a chunk of a file through a helper bot
+func (w *Worker) chunk(ctx context.Context, meta *storage.FileMetadata, offset, limit int64) ([]byte, error) {
+	location, err := w.location(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &tg.UploadGetFileRequest{Location: location, Offset: offset, Limit: int(limit)}
+	res, err := uploadGetFile(ctx, w.api, req)
+	if err != nil && isFileReferenceError(err) {
+		w.forget(meta.FileID)
+		if location, err = w.location(ctx, meta); err != nil {
+			return nil, err
+		}
+		req.Location = location
+		res, err = uploadGetFile(ctx, w.api, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fileBytes(res)
+}
+
+// location returns this worker's location of a file, resolving it through the file's source channel
+func (w *Worker) location(ctx context.Context, meta *storage.FileMetadata) (*tg.InputDocumentFileLocation, error) {
+	w.mu.Lock()
+	location, ok := w.locations[meta.FileID]
+	channelHash, channelKnown := w.channels[meta.SourceChannelID]
+	w.mu.Unlock()
+	if ok {
+		return location, nil
+	}
+
+	if !channelKnown {
+		channel, err := ResolveChannel(ctx, w.api, meta.SourceChannelID)
+		if err != nil {
+			return nil, err
+		}
+		channelHash = channel.AccessHash
+	}
+
+	res, err := w.api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+		Channel: &tg.InputChannel{ChannelID: meta.SourceChannelID, AccessHash: channelHash},
+		ID:      []tg.InputMessageClass{&tg.InputMessageID{ID: meta.SourceMessageID}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch source message: %w", err)
+	}
+	modified, ok := res.AsModified()
+	if !ok {
+		return nil, fmt.Errorf("unexpected response type: %T", res)
+	}
+
+	for _, m := range modified.GetMessages() {
+		msg, ok := m.(*tg.Message)
+		if !ok || msg.ID != meta.SourceMessageID || msg.Media == nil {
+			continue
+		}
+		file, err := ExtractFileMetadata(msg.Media)
+		if err != nil || file == nil || file.FileID != meta.FileID {
+			break
+		}
+
+		location = &tg.InputDocumentFileLocation{
+			ID:            file.FileID,
+			AccessHash:    file.AccessHash,
+			FileReference: file.FileReference,
+		}
+		w.mu.Lock()
+		w.channels[meta.SourceChannelID] = channelHash
+		w.locations[meta.FileID] = location
+		w.mu.Unlock()
+		return location, nil
+	}
+	return nil, errors.New("file not found in its source message")
+}
+
+// forget drops a cached location whose file reference expired
+func (w *Worker) forget(fileID int64) {
+	w.mu.Lock()
+	delete(w.locations, fileID)
+	w.mu.Unlock()
+}
+
+// startWorkers signs in the extra bot tokens in the background and adds each to the pool once connected.
+// Every bot gets its own session file and no update handling; only the main bot answers messages.
+func (c *Client) startWorkers(ctx context.Context, tokens []string, logger *zap.Logger) {
+	for i, token := range tokens {
+		botID, _, _ := strings.Cut(token, ":")
+		name := fmt.Sprintf("bot%d", i+1)
+		sessionPath := filepath.Join(filepath.Dir(c.sessionPath), fmt.Sprintf("worker_%s.json", botID))
+
+		client := telegram.NewClient(c.apiID, c.apiHash, telegram.Options{
+			SessionStorage: &telegram.FileSessionStorage{Path: sessionPath},
+			NoUpdates:      true,
+			Logger:         logger.Named(name),
+		})
+
+		worker := &Worker{
+			Name:      name,
+			channels:  make(map[int64]int64),
+			locations: make(map[int64]*tg.InputDocumentFileLocation),
+		}
+
+		go func() {
+			err := client.Run(ctx, func(ctx context.Context) error {
+				status, err := client.Auth().Status(ctx)
+				if err != nil {
+					return fmt.Errorf("auth status check failed: %w", err)
+				}
+				if !status.Authorized {
+					if _, err := client.Auth().Bot(ctx, token); err != nil {
+						return fmt.Errorf("bot authentication failed: %w", err)
+					}
+				}
+
+				worker.api = client.API()
+				c.workers.add(worker)
+				log.Printf("✅ Download worker %s connected", name)
+
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			c.workers.remove(worker)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("❌ Download worker %s stopped: %v", name, err)
+			}
+		}()
+	}
+}